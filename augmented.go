@@ -0,0 +1,318 @@
+package treaps
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Node of an augmented treap. agg summarizes the whole subtree in the same way that count
+// summarizes its number of nodes
+type augmentedNode struct {
+	key      interface{}
+	priority uint64
+	count    int
+	agg      interface{} // combine(llink.agg, key, rlink.agg)
+	llink    *augmentedNode
+	rlink    *augmentedNode
+}
+
+// AugmentedTreap An ordered set of keys where every node keeps, besides its count, an aggregate of
+// its subtree computed through a user function combine(left, key, right). left and right are the
+// aggregates of the subtrees and the aggregate of the empty tree is identity. combine must be
+// associative in the sense of a monoid: combine(combine(a, k1, b), k2, c) is the same value than
+// combine(a, k1, combine(b, k2, c)) whenever b is the aggregate of the keys in between. Sums, maxima,
+// minima or counts of keys satisfying a predicate are typical aggregates.
+//
+// The aggregates are recomputed on every node whose subtree changes, so insertions and removals
+// keep their O(log n) expected complexity
+type AugmentedTreap struct {
+	randGenerator *rand.Rand
+	null          *augmentedNode // sentinel of this tree; its aggregate is identity
+	root          *augmentedNode
+	Less          func(i1, i2 interface{}) bool
+	combine       func(left, key, right interface{}) interface{}
+}
+
+// NewAugmented Create a new augmented treap with a random generator set to seed, comparison
+// function less, aggregate of the empty tree identity and aggregate function combine
+func NewAugmented(seed int64, less func(i1, i2 interface{}) bool, identity interface{},
+	combine func(left, key, right interface{}) interface{}) *AugmentedTreap {
+
+	null := &augmentedNode{priority: math.MaxUint64, agg: identity}
+	null.llink, null.rlink = null, null
+
+	return &AugmentedTreap{
+		randGenerator: rand.New(rand.NewSource(seed)),
+		null:          null,
+		root:          null,
+		Less:          less,
+		combine:       combine,
+	}
+}
+
+// NewAugmentedTreap Create a new augmented treap with random seed chosen from system clock
+func NewAugmentedTreap(less func(i1, i2 interface{}) bool, identity interface{},
+	combine func(left, key, right interface{}) interface{}) *AugmentedTreap {
+	return NewAugmented(time.Now().UTC().UnixNano(), less, identity, combine)
+}
+
+// Recompute count and aggregate of p from its children
+func (tree *AugmentedTreap) update(p *augmentedNode) {
+	p.count = p.llink.count + 1 + p.rlink.count
+	p.agg = tree.combine(p.llink.agg, p.key, p.rlink.agg)
+}
+
+// Rotate p to the right. Left child becomes root
+func (tree *AugmentedTreap) rotateRight(p *augmentedNode) *augmentedNode {
+	q := p.llink
+	p.llink = q.rlink
+	q.rlink = p
+	tree.update(p)
+	tree.update(q)
+	return q
+}
+
+// Rotate p to the left. Right child becomes root
+func (tree *AugmentedTreap) rotateLeft(p *augmentedNode) *augmentedNode {
+	q := p.rlink
+	p.rlink = q.llink
+	q.llink = p
+	tree.update(p)
+	tree.update(q)
+	return q
+}
+
+// Helper for inserting p into tree root. Return the new root or null if the key is already
+// contained
+func (tree *AugmentedTreap) __insert(root, p *augmentedNode) *augmentedNode {
+
+	if root == tree.null {
+		return p
+	}
+
+	if tree.Less(p.key, root.key) {
+		result := tree.__insert(root.llink, p)
+		if result == tree.null {
+			return tree.null
+		}
+		root.llink = result
+		if result.priority < root.priority {
+			return tree.rotateRight(root)
+		}
+	} else if tree.Less(root.key, p.key) {
+		result := tree.__insert(root.rlink, p)
+		if result == tree.null {
+			return tree.null
+		}
+		root.rlink = result
+		if result.priority < root.priority {
+			return tree.rotateLeft(root)
+		}
+	} else {
+		return tree.null // key is already in tree ==> insertion fails
+	}
+
+	tree.update(root)
+	return root
+}
+
+// Insert item into the tree. Return nil if the key is already contained; otherwise return item
+func (tree *AugmentedTreap) Insert(item interface{}) interface{} {
+
+	p := &augmentedNode{
+		key:      item,
+		priority: tree.randGenerator.Uint64(),
+		llink:    tree.null,
+		rlink:    tree.null,
+	}
+	tree.update(p)
+
+	result := tree.__insert(tree.root, p)
+	if result == tree.null {
+		return nil
+	}
+
+	tree.root = result
+	return item
+}
+
+// Helper that joins two range-disjoint trees
+func (tree *AugmentedTreap) __joinExclusive(ts, tg *augmentedNode) *augmentedNode {
+
+	if ts == tree.null {
+		return tg
+	}
+
+	if tg == tree.null {
+		return ts
+	}
+
+	if ts.priority < tg.priority {
+		ts.rlink = tree.__joinExclusive(ts.rlink, tg)
+		tree.update(ts)
+		return ts
+	}
+
+	tg.llink = tree.__joinExclusive(ts, tg.llink)
+	tree.update(tg)
+	return tg
+}
+
+// Helper that removes key from tree root. Return the new root and the removed node, which is null
+// if the key is not found
+func (tree *AugmentedTreap) __remove(root *augmentedNode,
+	key interface{}) (*augmentedNode, *augmentedNode) {
+
+	if root == tree.null {
+		return tree.null, tree.null
+	}
+
+	var removed *augmentedNode
+	if tree.Less(key, root.key) {
+		root.llink, removed = tree.__remove(root.llink, key)
+	} else if tree.Less(root.key, key) {
+		root.rlink, removed = tree.__remove(root.rlink, key)
+	} else { // key found
+		return tree.__joinExclusive(root.llink, root.rlink), root
+	}
+
+	if removed != tree.null {
+		tree.update(root)
+	}
+
+	return root, removed
+}
+
+// Remove key from the tree. Return the removed key or nil if it was not found
+func (tree *AugmentedTreap) Remove(key interface{}) interface{} {
+
+	var removed *augmentedNode
+	tree.root, removed = tree.__remove(tree.root, key)
+	if removed == tree.null {
+		return nil
+	}
+
+	return removed.key
+}
+
+// Search Return the key contained in the tree equal to key or nil if it is not found
+func (tree *AugmentedTreap) Search(key interface{}) interface{} {
+
+	root := tree.root
+	for root != tree.null {
+		if tree.Less(key, root.key) {
+			root = root.llink
+		} else if tree.Less(root.key, key) {
+			root = root.rlink
+		} else {
+			return root.key
+		}
+	}
+
+	return nil
+}
+
+// Has Return true if key is contained in the tree
+func (tree *AugmentedTreap) Has(key interface{}) bool { return tree.Search(key) != nil }
+
+// Size Return the number of keys
+func (tree *AugmentedTreap) Size() int { return tree.root.count }
+
+// IsEmpty Return true if the tree has no keys
+func (tree *AugmentedTreap) IsEmpty() bool { return tree.root == tree.null }
+
+// Choose Return the key at the position pos. Panic if pos is out of range
+func (tree *AugmentedTreap) Choose(pos int) interface{} {
+
+	if pos < 0 || pos >= tree.Size() {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+
+	root := tree.root
+	for pos != root.llink.count {
+		if pos < root.llink.count {
+			root = root.llink
+		} else {
+			pos -= root.llink.count + 1
+			root = root.rlink
+		}
+	}
+
+	return root.key
+}
+
+// Aggregate Return in O(1) the aggregate of all the keys; identity if the tree is empty
+func (tree *AugmentedTreap) Aggregate() interface{} { return tree.root.agg }
+
+// Helper that returns the aggregate of the keys of root that are greater or equal than lo
+func (tree *AugmentedTreap) __aggregateFrom(root *augmentedNode, lo interface{}) interface{} {
+
+	if root == tree.null {
+		return root.agg
+	}
+
+	if tree.Less(root.key, lo) {
+		return tree.__aggregateFrom(root.rlink, lo)
+	}
+
+	return tree.combine(tree.__aggregateFrom(root.llink, lo), root.key, root.rlink.agg)
+}
+
+// Helper that returns the aggregate of the keys of root that are less or equal than hi
+func (tree *AugmentedTreap) __aggregateUpTo(root *augmentedNode, hi interface{}) interface{} {
+
+	if root == tree.null {
+		return root.agg
+	}
+
+	if tree.Less(hi, root.key) {
+		return tree.__aggregateUpTo(root.llink, hi)
+	}
+
+	return tree.combine(root.llink.agg, root.key, tree.__aggregateUpTo(root.rlink, hi))
+}
+
+// RangeAggregate Return the aggregate of the keys in [lo, hi]; identity if there is not any. The
+// tree is not modified and the computation spends O(log n) expected time: below the node where
+// the search paths of lo and hi diverge, the aggregates of the subtrees fully contained in the
+// range are taken from the nodes
+func (tree *AugmentedTreap) RangeAggregate(lo, hi interface{}) interface{} {
+
+	root := tree.root
+	for root != tree.null {
+		if tree.Less(root.key, lo) {
+			root = root.rlink
+		} else if tree.Less(hi, root.key) {
+			root = root.llink
+		} else { // root.key in [lo, hi]
+			return tree.combine(tree.__aggregateFrom(root.llink, lo), root.key,
+				tree.__aggregateUpTo(root.rlink, hi))
+		}
+	}
+
+	return root.agg
+}
+
+// Traverse Execute operation on every key in ascending order until operation returns false.
+// Return true if all the keys were visited
+func (tree *AugmentedTreap) Traverse(operation func(key interface{}) bool) bool {
+
+	stack := make([]*augmentedNode, 0, 64)
+	for p := tree.root; p != tree.null || len(stack) > 0; {
+		if p != tree.null {
+			stack = append(stack, p)
+			p = p.llink
+			continue
+		}
+		p = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !operation(p.key) {
+			return false
+		}
+		p = p.rlink
+	}
+
+	return true
+}
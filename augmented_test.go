@@ -0,0 +1,123 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func sumCombine(left, key, right interface{}) interface{} {
+	return left.(int) + key.(int) + right.(int)
+}
+
+func maxCombine(left, key, right interface{}) interface{} {
+	ret := key.(int)
+	if left.(int) > ret {
+		ret = left.(int)
+	}
+	if right.(int) > ret {
+		ret = right.(int)
+	}
+	return ret
+}
+
+// Helper that verifies order, heap, count and aggregate of every node of an augmented treap
+func checkAugmented(tree *AugmentedTreap, p *augmentedNode) bool {
+
+	if p == tree.null {
+		return true
+	}
+
+	if p.llink != tree.null && (!tree.Less(p.llink.key, p.key) || p.llink.priority < p.priority) {
+		return false
+	}
+	if p.rlink != tree.null && (!tree.Less(p.key, p.rlink.key) || p.rlink.priority < p.priority) {
+		return false
+	}
+	if p.count != p.llink.count+1+p.rlink.count {
+		return false
+	}
+	if p.agg != tree.combine(p.llink.agg, p.key, p.rlink.agg) {
+		return false
+	}
+
+	return checkAugmented(tree, p.llink) && checkAugmented(tree, p.rlink)
+}
+
+func TestAugmentedTreap_RangeAggregate(t *testing.T) {
+
+	const N = 500
+	sums := NewAugmented(1, cmpInt, 0, sumCombine)
+	maxs := NewAugmented(2, cmpInt, math.MinInt64, maxCombine)
+	present := make(map[int]bool)
+	for i := 0; i < 4*N; i++ {
+		key := rand.Intn(N)
+		if rand.Intn(3) == 0 {
+			assert.Equal(t, present[key], sums.Remove(key) != nil)
+			maxs.Remove(key)
+			delete(present, key)
+		} else {
+			assert.Equal(t, !present[key], sums.Insert(key) != nil)
+			maxs.Insert(key)
+			present[key] = true
+		}
+	}
+	assert.True(t, checkAugmented(sums, sums.root))
+	assert.True(t, checkAugmented(maxs, maxs.root))
+	assert.Equal(t, len(present), sums.Size())
+
+	for i := 0; i < 1000; i++ {
+		lo, hi := rand.Intn(N+10)-5, rand.Intn(N+10)-5
+		sum, max := 0, math.MinInt64
+		for key := range present {
+			if key >= lo && key <= hi {
+				sum += key
+				if key > max {
+					max = key
+				}
+			}
+		}
+		assert.Equal(t, sum, sums.RangeAggregate(lo, hi), "sum of [%d, %d]", lo, hi)
+		assert.Equal(t, max, maxs.RangeAggregate(lo, hi), "max of [%d, %d]", lo, hi)
+	}
+
+	total := 0
+	for key := range present {
+		total += key
+	}
+	assert.Equal(t, total, sums.Aggregate())
+}
+
+func TestAugmentedTreap_Basics(t *testing.T) {
+
+	tree := NewAugmentedTreap(cmpInt, 0, sumCombine)
+	assert.True(t, tree.IsEmpty())
+	assert.Equal(t, 0, tree.Aggregate())
+	assert.Equal(t, 0, tree.RangeAggregate(1, 10))
+
+	for _, key := range []int{5, 3, 8, 1} {
+		tree.Insert(key)
+	}
+	assert.Nil(t, tree.Insert(3))
+	assert.Equal(t, 4, tree.Size())
+	assert.True(t, tree.Has(8))
+	assert.Nil(t, tree.Search(4))
+	assert.Equal(t, 1, tree.Choose(0))
+	assert.Equal(t, 8, tree.Choose(3))
+	assert.Panics(t, func() { tree.Choose(4) })
+	assert.Equal(t, 8, tree.RangeAggregate(3, 5))
+	assert.Equal(t, 0, tree.RangeAggregate(6, 7))
+
+	keys := make([]interface{}, 0)
+	tree.Traverse(func(key interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []interface{}{1, 3, 5, 8}, keys)
+
+	assert.Equal(t, 5, tree.Remove(5))
+	assert.Nil(t, tree.Remove(5))
+	assert.Equal(t, 12, tree.Aggregate())
+	assert.True(t, checkAugmented(tree, tree.root))
+}
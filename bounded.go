@@ -0,0 +1,65 @@
+package treaps
+
+import (
+	"fmt"
+	"time"
+)
+
+// BoundedTreap A set of at most capacity keys. When an insertion makes it exceed its capacity,
+// the minimum key is evicted, or the maximum if it was created with evictMax. So, evicting the
+// minimum, the set keeps the capacity largest keys ever inserted, which is a running top-N. Every
+// insertion spends O(log n) expected time
+type BoundedTreap struct {
+	tree     *Treap
+	capacity int
+	evictMax bool // the maximum is evicted instead of the minimum
+}
+
+// NewBounded Create a new bounded set of capacity keys ordered by less, with random seed chosen
+// from system clock. items are inserted as Insert does. Panic if capacity is not positive
+func NewBounded(capacity int, evictMax bool, less func(i1, i2 interface{}) bool,
+	items ...interface{}) *BoundedTreap {
+
+	if capacity <= 0 {
+		panic(fmt.Sprintf("Invalid capacity %d", capacity))
+	}
+
+	bounded := &BoundedTreap{
+		tree:     New(time.Now().UTC().UnixNano(), less),
+		capacity: capacity,
+		evictMax: evictMax,
+	}
+
+	for _, item := range items {
+		bounded.Insert(item)
+	}
+
+	return bounded
+}
+
+// Tree Return the underlying treap for querying it. Inserting directly into it bypasses the
+// capacity, which is only enforced by Insert
+func (bounded *BoundedTreap) Tree() *Treap { return bounded.tree }
+
+// Capacity Return the maximum number of keys
+func (bounded *BoundedTreap) Capacity() int { return bounded.capacity }
+
+// Size Return the number of keys
+func (bounded *BoundedTreap) Size() int { return bounded.tree.Size() }
+
+// Insert Insert item and, if the set exceeds its capacity, evict its minimum key, or its maximum
+// if evictMax was set. Return the evicted key, which can be item itself if it is the extreme, or
+// nil if nothing was evicted. If item is already contained, then the set is not modified and nil
+// is returned
+func (bounded *BoundedTreap) Insert(item interface{}) interface{} {
+
+	if bounded.tree.Insert(item) == nil || bounded.tree.Size() <= bounded.capacity {
+		return nil
+	}
+
+	if bounded.evictMax {
+		return bounded.tree.ExtractMax()
+	}
+
+	return bounded.tree.ExtractMin()
+}
@@ -0,0 +1,48 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBoundedTreap_TopN(t *testing.T) {
+
+	const N, capacity = 1000, 10
+	bounded := NewBounded(capacity, false, cmpInt)
+	values := rand.New(rand.NewSource(1)).Perm(N)
+
+	evicted := 0
+	for i, value := range values {
+		key := bounded.Insert(value)
+		if i < capacity {
+			assert.Nil(t, key)
+		} else {
+			assert.NotNil(t, key)
+			evicted++
+		}
+		assert.True(t, bounded.Size() <= capacity)
+	}
+	assert.Equal(t, N-capacity, evicted)
+
+	sort.Ints(values)
+	for i, key := range bounded.Tree().ToSlice() {
+		assert.Equal(t, values[N-capacity+i], key)
+	}
+
+	assert.Nil(t, bounded.Insert(N-1))    // already contained
+	assert.Equal(t, 0, bounded.Insert(0)) // the new key is the evicted one
+	assert.Equal(t, capacity, bounded.Size())
+}
+
+func TestBoundedTreap_EvictMax(t *testing.T) {
+
+	bounded := NewBounded(3, true, cmpInt, 5, 1, 4, 2, 3)
+	assert.Equal(t, 3, bounded.Capacity())
+	assert.Equal(t, []interface{}{1, 2, 3}, bounded.Tree().ToSlice())
+	assert.Equal(t, 3, bounded.Insert(0))
+	assert.Equal(t, []interface{}{0, 1, 2}, bounded.Tree().ToSlice())
+
+	assert.Panics(t, func() { NewBounded(0, true, cmpInt) })
+}
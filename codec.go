@@ -0,0 +1,257 @@
+package treaps
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Binary format written by WriteTo: the header, a byte telling whether the tree is not empty and
+// then the nodes in preorder. Every node is written as a flags byte, its priority as 8 big endian
+// bytes and its key as written by the user function. The flags tell whether the node has left and
+// right children, so that the shape and the priorities are rebuilt exactly
+var codecHeader = []byte("TRP\x01")
+
+const (
+	flagLeft     = 1 << iota // the node has a left child
+	flagRight                // the node has a right child
+	flagReversed             // the node has a pending reversal
+)
+
+// Writer that counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Reader that counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// Helper that writes the nodes of root in preorder
+func __writeNodes(w io.Writer, root *Node, encodeKey func(io.Writer, interface{}) error) error {
+
+	var record [9]byte
+	if root.llink != nullNodePtr {
+		record[0] |= flagLeft
+	}
+	if root.rlink != nullNodePtr {
+		record[0] |= flagRight
+	}
+	if root.reversed {
+		record[0] |= flagReversed
+	}
+	binary.BigEndian.PutUint64(record[1:], root.priority)
+
+	if _, err := w.Write(record[:]); err != nil {
+		return err
+	}
+	if err := encodeKey(w, root.key); err != nil {
+		return err
+	}
+
+	if root.llink != nullNodePtr {
+		if err := __writeNodes(w, root.llink, encodeKey); err != nil {
+			return err
+		}
+	}
+	if root.rlink != nullNodePtr {
+		if err := __writeNodes(w, root.rlink, encodeKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteTo Write tree to w in a binary format that keeps the shape and the priorities of the tree.
+// Keys are written by encodeKey. The nodes are streamed in preorder, so nothing is buffered
+// besides the recursion on the height of the tree. Return the number of bytes written, included
+// those written by encodeKey, and the first error found
+func (tree *Treap) WriteTo(w io.Writer, encodeKey func(io.Writer, interface{}) error) (int64, error) {
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(codecHeader); err != nil {
+		return cw.n, err
+	}
+
+	root := *tree.rootPtr
+	notEmpty := []byte{0}
+	if root != nullNodePtr {
+		notEmpty[0] = 1
+	}
+	if _, err := cw.Write(notEmpty); err != nil {
+		return cw.n, err
+	}
+
+	if root != nullNodePtr {
+		if err := __writeNodes(cw, root, encodeKey); err != nil {
+			return cw.n, err
+		}
+	}
+
+	return cw.n, nil
+}
+
+// Helper that reads a node and its subtrees written by __writeNodes. The nodes are taken from alloc
+func __readNodes(r io.Reader, decodeKey func(io.Reader) (interface{}, error),
+	alloc func() *Node) (*Node, error) {
+
+	var record [9]byte
+	if _, err := io.ReadFull(r, record[:]); err != nil {
+		return nil, err
+	}
+	flags := record[0]
+	if flags&^(flagLeft|flagRight|flagReversed) != 0 {
+		return nil, fmt.Errorf("invalid node flags %#x", flags)
+	}
+
+	key, err := decodeKey(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := alloc()
+	p.key = key
+	p.priority = binary.BigEndian.Uint64(record[1:])
+	p.reversed = flags&flagReversed != 0
+
+	if flags&flagLeft != 0 {
+		if p.llink, err = __readNodes(r, decodeKey, alloc); err != nil {
+			return nil, err
+		}
+	}
+	if flags&flagRight != 0 {
+		if p.rlink, err = __readNodes(r, decodeKey, alloc); err != nil {
+			return nil, err
+		}
+	}
+	p.count = p.llink.count + 1 + p.rlink.count
+
+	return p, nil
+}
+
+// Helper that reads into tree a stream written by WriteTo. Return the number of bytes read. The
+// decoded tree must be in heap order and, unless it has pending reversals, in BST order; otherwise
+// an error is returned. On error, the nodes already decoded are released and tree is not modified.
+// On success, the replaced nodes of tree are released as Clear does
+func (tree *Treap) readFrom(r io.Reader, decodeKey func(io.Reader) (interface{}, error)) (int64, error) {
+
+	cr := &countingReader{r: r}
+	header := make([]byte, len(codecHeader)+1)
+	if _, err := io.ReadFull(cr, header); err != nil {
+		return cr.n, err
+	}
+	if string(header[:len(codecHeader)]) != string(codecHeader) {
+		return cr.n, errors.New("invalid treap header")
+	}
+
+	nodes := make([]*Node, 0, 64) // decoded nodes, released if the stream is invalid
+	alloc := func() *Node {
+		p := tree.allocNode()
+		nodes = append(nodes, p)
+		return p
+	}
+
+	root := nullNodePtr
+	switch header[len(codecHeader)] {
+	case 0:
+	case 1:
+		var err error
+		if root, err = __readNodes(cr, decodeKey, alloc); err == nil {
+			err = __validateDecoded(root, nodes, tree.Less)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			for _, p := range nodes {
+				tree.releaseNode(p)
+			}
+			return cr.n, err
+		}
+	default:
+		return cr.n, errors.New("invalid treap header")
+	}
+
+	tree.modified()
+	old := *tree.rootPtr
+	*tree.rootPtr = root
+	if tree.pooled || tree.free != nil {
+		tree.__releaseTree(old)
+	}
+	return cr.n, nil
+}
+
+// Helper that verifies the invariants of the decoded tree root, whose nodes are nodes. The BST
+// order is not verified if a node has a pending reversal, since such tree is a sequence whose keys
+// are not ordered. See ReverseRange
+func __validateDecoded(root *Node, nodes []*Node, less func(i1, i2 interface{}) bool) error {
+
+	if err := __validateHeap(root); err != nil {
+		return err
+	}
+
+	for _, p := range nodes {
+		if p.reversed {
+			return nil
+		}
+	}
+
+	return __validateBST(root, nil, nil, less)
+}
+
+// ReadFrom Read from r a tree written by WriteTo. Keys are read by decodeKey and ordered by less.
+// The shape and the priorities of the written tree are restored, so the result is topologically
+// equal to the written tree. The random generator of the new tree is seeded from the system clock.
+// Return an error if the decoded keys are not in BST order respect to less or the priorities are
+// not in heap order, which happens when the stream is corrupted
+func ReadFrom(r io.Reader, less func(i1, i2 interface{}) bool,
+	decodeKey func(io.Reader) (interface{}, error)) (*Treap, error) {
+
+	tree := New(time.Now().UTC().UnixNano(), less)
+	if _, err := tree.readFrom(r, decodeKey); err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+// TreapWriter Adapter of a treap to io.WriterTo
+type TreapWriter struct {
+	Tree      *Treap
+	EncodeKey func(io.Writer, interface{}) error
+}
+
+// WriteTo Write the tree to w. See Treap.WriteTo
+func (tw *TreapWriter) WriteTo(w io.Writer) (int64, error) {
+	return tw.Tree.WriteTo(w, tw.EncodeKey)
+}
+
+// TreapReader Adapter of a treap to io.ReaderFrom. The content of Tree is replaced by the tree read
+type TreapReader struct {
+	Tree      *Treap
+	DecodeKey func(io.Reader) (interface{}, error)
+}
+
+// ReadFrom Read from r a tree written by WriteTo. Tree is not modified if there is an error.
+// Otherwise, its previous nodes are released as Clear does
+func (tr *TreapReader) ReadFrom(r io.Reader) (int64, error) {
+	return tr.Tree.readFrom(r, tr.DecodeKey)
+}
@@ -0,0 +1,157 @@
+package treaps
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func encodeInt(w io.Writer, key interface{}) error {
+	return binary.Write(w, binary.BigEndian, int64(key.(int)))
+}
+
+func decodeInt(r io.Reader) (interface{}, error) {
+	var key int64
+	err := binary.Read(r, binary.BigEndian, &key)
+	return int(key), err
+}
+
+func TestTreap_WriteToReadFrom(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	insertNRandomItems(tree, 1000)
+
+	var buf bytes.Buffer
+	n, err := tree.WriteTo(&buf, encodeInt)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, int64(len(codecHeader)+1+tree.Size()*(9+8)), n)
+
+	read, err := ReadFrom(&buf, cmpInt, decodeInt)
+	assert.NoError(t, err)
+	assert.True(t, read.check())
+	assert.True(t, read.TopologicalEqual(tree), "shape and priorities are kept")
+	assert.True(t, read.Equal(tree))
+
+	buf.Reset()
+	_, err = NewTreap(cmpInt).WriteTo(&buf, encodeInt)
+	assert.NoError(t, err)
+	empty, err := ReadFrom(&buf, cmpInt, decodeInt)
+	assert.NoError(t, err)
+	assert.True(t, empty.IsEmpty())
+}
+
+func TestTreap_ReadFromErrors(t *testing.T) {
+
+	var buf bytes.Buffer
+	_, err := New(1, cmpInt, 1, 2, 3).WriteTo(&buf, encodeInt)
+	assert.NoError(t, err)
+	data := buf.Bytes()
+
+	_, err = ReadFrom(bytes.NewReader(data[:len(data)-3]), cmpInt, decodeInt)
+	assert.Error(t, err)
+
+	_, err = ReadFrom(bytes.NewReader(data[:len(codecHeader)+1]), cmpInt, decodeInt)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = ReadFrom(bytes.NewReader([]byte("XXXX\x01")), cmpInt, decodeInt)
+	assert.Error(t, err)
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(codecHeader)+1] = 0xF0 // flags of the root
+	_, err = ReadFrom(bytes.NewReader(corrupted), cmpInt, decodeInt)
+	assert.Error(t, err)
+
+	failure := errors.New("failure")
+	_, err = New(1, cmpInt, 1).WriteTo(&buf, func(io.Writer, interface{}) error { return failure })
+	assert.Equal(t, failure, err)
+}
+
+// Helper that appends to data a node record written as __writeNodes does
+func appendRecord(data []byte, flags byte, priority uint64, key int) []byte {
+	var record [17]byte
+	record[0] = flags
+	binary.BigEndian.PutUint64(record[1:], priority)
+	binary.BigEndian.PutUint64(record[9:], uint64(key))
+	return append(data, record[:]...)
+}
+
+func TestTreap_ReadFromInvalidTree(t *testing.T) {
+
+	header := append(append([]byte{}, codecHeader...), 1)
+
+	disordered := appendRecord(appendRecord(header, flagLeft, 1, 1), 0, 2, 2)
+	_, err := ReadFrom(bytes.NewReader(disordered), cmpInt, decodeInt)
+	assert.Regexp(t, "BST order violated", err)
+
+	unbalanced := appendRecord(appendRecord(header, flagLeft, 2, 2), 0, 1, 1)
+	_, err = ReadFrom(bytes.NewReader(unbalanced), cmpInt, decodeInt)
+	assert.Regexp(t, "heap order violated", err)
+
+	// a tree with pending reversals is a sequence, so its keys are not required to be ordered
+	sequence := New(1, cmpInt, 1, 2, 3, 4, 5)
+	sequence.ReverseRange(1, 3)
+	var buf bytes.Buffer
+	_, err = sequence.WriteTo(&buf, encodeInt)
+	assert.NoError(t, err)
+	read, err := ReadFrom(&buf, cmpInt, decodeInt)
+	assert.NoError(t, err)
+	assert.Equal(t, sequence.ToSlice(), read.ToSlice())
+}
+
+func TestTreapReader_ReleasesNodes(t *testing.T) {
+
+	var buf bytes.Buffer
+	_, err := New(1, cmpInt, 1, 2, 3).WriteTo(&buf, encodeInt)
+	assert.NoError(t, err)
+	data := buf.Bytes()
+
+	tree := NewPooled(2, cmpInt, 10, 20)
+	reader := &TreapReader{Tree: tree, DecodeKey: decodeInt}
+
+	// the two nodes decoded before the error go back to the pool and tree is not modified
+	_, err = reader.ReadFrom(bytes.NewReader(data[:len(data)-3]))
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(tree.freeList))
+	assert.Equal(t, "[10 20]", tree.String())
+
+	// the pool gives two of the three decoded nodes and receives the two replaced ones
+	_, err = reader.ReadFrom(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "[1 2 3]", tree.String())
+	assert.Equal(t, 2, len(tree.freeList))
+	assert.True(t, tree.check())
+
+	live := 0
+	alloc := func() *Node {
+		live++
+		return new(Node)
+	}
+	allocating := NewWithAllocator(3, cmpInt, alloc, func(*Node) { live-- }, 7)
+	_, err = allocating.readFrom(bytes.NewReader(data[:len(data)-3]), decodeInt)
+	assert.Error(t, err)
+	assert.Equal(t, 1, live)
+	_, err = allocating.readFrom(bytes.NewReader(data), decodeInt)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, live)
+}
+
+func TestTreap_WriterToReaderFromAdapters(t *testing.T) {
+
+	tree := New(1, cmpInt, 5, 3, 8, 1)
+
+	var buf bytes.Buffer
+	var writerTo io.WriterTo = &TreapWriter{Tree: tree, EncodeKey: encodeInt}
+	written, err := writerTo.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	read := NewTreap(cmpInt, 100)
+	var readerFrom io.ReaderFrom = &TreapReader{Tree: read, DecodeKey: decodeInt}
+	n, err := readerFrom.ReadFrom(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, written, n)
+	assert.True(t, read.TopologicalEqual(tree))
+}
@@ -0,0 +1,64 @@
+package treaps
+
+import "sync"
+
+// ConcurrentTreap A treap safe for concurrent use. Readers take a shared lock and mutators an
+// exclusive one. Iterators must not be created on the underlying tree; instead, take a Snapshot
+// and iterate on it. Iterators on a snapshot are safe because the snapshot is not shared
+type ConcurrentTreap struct {
+	lock sync.RWMutex
+	tree *Treap
+}
+
+// NewConcurrentTreap Create a new concurrent treap with random seed chosen from system clock
+func NewConcurrentTreap(less func(i1, i2 interface{}) bool, items ...interface{}) *ConcurrentTreap {
+	return &ConcurrentTreap{
+		tree: NewTreap(less, items...),
+	}
+}
+
+// Insert item into the tree. Return nil if key is already contained; otherwise
+// returns the value of the just inserted item
+func (ct *ConcurrentTreap) Insert(item interface{}) interface{} {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return ct.tree.Insert(item)
+}
+
+// Remove key from the tree. Return the removed value if the removal was successful.
+// Otherwise, the item was not found and the value nil is returned
+func (ct *ConcurrentTreap) Remove(key interface{}) interface{} {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return ct.tree.Remove(key)
+}
+
+// Search in tree key. If key is found, then the value contained in the set is returned.
+// Otherwise, nil value is returned
+func (ct *ConcurrentTreap) Search(key interface{}) interface{} {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.Search(key)
+}
+
+// Has Return true if key is found in tree
+func (ct *ConcurrentTreap) Has(key interface{}) bool {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.Has(key)
+}
+
+// Size Return the number of keys contained in the tree
+func (ct *ConcurrentTreap) Size() int {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.Size()
+}
+
+// Snapshot Return a consistent copy of the tree taken under the read lock. The copy is not
+// shared, so it can be freely iterated or modified
+func (ct *ConcurrentTreap) Snapshot() *Treap {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.Copy()
+}
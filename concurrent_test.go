@@ -0,0 +1,49 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentTreap(t *testing.T) {
+
+	const N = 1000
+	const W = 8
+	set := NewConcurrentTreap(cmpInt)
+
+	var wg sync.WaitGroup
+	for w := 0; w < W; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < N; i += W {
+				assert.NotNil(t, set.Insert(i))
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < N; i++ {
+				set.Has(i)
+				set.Size()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, N, set.Size())
+	snapshot := set.Snapshot()
+	assert.True(t, snapshot.check())
+	for i, it := 0, NewIterator(snapshot); it.HasCurr(); i, it = i+1, it.Next() {
+		assert.Equal(t, i, it.GetCurr())
+		assert.Equal(t, i, set.Search(i))
+	}
+
+	for i := 0; i < N; i += 2 {
+		assert.Equal(t, i, set.Remove(i))
+	}
+	assert.Equal(t, N/2, set.Size())
+	assert.Equal(t, N, snapshot.Size(), "snapshot is not affected by later mutations")
+	assert.False(t, set.Has(0))
+	assert.True(t, set.Has(1))
+}
@@ -0,0 +1,67 @@
+package treaps
+
+// HeapAdapter Adapter of a treap to container/heap.Interface, so that the treap can be used as
+// a min or max priority queue while keeping its order statistics.
+//
+// Since the treap is always ordered, the heap order always holds: Swap does nothing, Push inserts
+// the key according to its order and Pop extracts the minimum (or the maximum) instead of the
+// element at position Len() - 1. So heap.Init, heap.Push and heap.Pop work as expected, but
+// heap.Fix and heap.Remove are meaningless. If the adapter was not created with dup set, then
+// pushing a key already contained is ignored
+type HeapAdapter struct {
+	tree *Treap
+	max  bool // the top of heap is the maximum
+	dup  bool // keys are inserted with InsertDup
+}
+
+// NewMinHeap Return a heap.Interface on tree whose top is the minimum key
+func NewMinHeap(tree *Treap, dup bool) *HeapAdapter {
+	return &HeapAdapter{tree: tree, max: false, dup: dup}
+}
+
+// NewMaxHeap Return a heap.Interface on tree whose top is the maximum key
+func NewMaxHeap(tree *Treap, dup bool) *HeapAdapter {
+	return &HeapAdapter{tree: tree, max: true, dup: dup}
+}
+
+// Tree Return the adapted treap
+func (h *HeapAdapter) Tree() *Treap { return h.tree }
+
+// Return the key at the heap position i
+func (h *HeapAdapter) keyAt(i int) interface{} {
+	if h.max {
+		return h.tree.Choose(h.tree.Size() - 1 - i)
+	}
+	return h.tree.Choose(i)
+}
+
+// Len Return the number of keys of the tree
+func (h *HeapAdapter) Len() int { return h.tree.Size() }
+
+// Less Compare the keys at the heap positions i and j
+func (h *HeapAdapter) Less(i, j int) bool {
+	if h.max {
+		return h.tree.Less(h.keyAt(j), h.keyAt(i))
+	}
+	return h.tree.Less(h.keyAt(i), h.keyAt(j))
+}
+
+// Swap Do nothing, the keys are always in heap order
+func (h *HeapAdapter) Swap(i, j int) {}
+
+// Push Insert x into the tree
+func (h *HeapAdapter) Push(x interface{}) {
+	if h.dup {
+		h.tree.InsertDup(x)
+	} else {
+		h.tree.Insert(x)
+	}
+}
+
+// Pop Remove and return the top of the heap
+func (h *HeapAdapter) Pop() interface{} {
+	if h.max {
+		return h.tree.ExtractMax()
+	}
+	return h.tree.ExtractMin()
+}
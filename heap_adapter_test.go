@@ -0,0 +1,48 @@
+package treaps
+
+import (
+	"container/heap"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestHeapAdapter_MinHeap(t *testing.T) {
+
+	const N = 1000
+	h := NewMinHeap(NewTreap(cmpInt), true)
+	heap.Init(h)
+
+	keys := make([]int, 0, N)
+	for i := 0; i < N; i++ {
+		key := rand.Intn(N / 2)
+		keys = append(keys, key)
+		heap.Push(h, key)
+	}
+	sort.Ints(keys)
+
+	assert.Equal(t, N, h.Len())
+	assert.Equal(t, keys[N/2], h.Tree().Choose(N/2), "order statistics are kept")
+
+	for _, key := range keys {
+		assert.Equal(t, key, heap.Pop(h))
+	}
+	assert.Equal(t, 0, h.Len())
+	assert.True(t, h.Tree().check())
+}
+
+func TestHeapAdapter_MaxHeap(t *testing.T) {
+
+	h := NewMaxHeap(NewTreap(cmpInt, 5, 1, 3), false)
+	heap.Init(h)
+	heap.Push(h, 4)
+	heap.Push(h, 4) // ignored because the adapter does not accept duplicates
+	heap.Push(h, 2)
+
+	assert.Equal(t, 5, h.Len())
+	assert.True(t, h.Less(0, 1))
+	for _, expected := range []int{5, 4, 3, 2, 1} {
+		assert.Equal(t, expected, heap.Pop(h))
+	}
+}
@@ -0,0 +1,255 @@
+package treaps
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Node of a LazyTreap. pending is an increment already applied to value and sum, but not yet to
+// the children
+type lazyNode struct {
+	value    interface{}
+	priority uint64
+	count    int
+	sum      interface{} // sum of the values of the subtree
+	pending  interface{} // nil if there is not pending increment
+	llink    *lazyNode
+	rlink    *lazyNode
+}
+
+// LazyTreap A sequence of values indexed by position which supports adding an increment to every
+// value of a positional range and querying the sum of a positional range, both in O(log n)
+// expected time. Range increments are not propagated immediately: the root of the range is
+// marked with a pending increment that is pushed down to its children when a later operation
+// descends through it.
+//
+// The values are not ordered; the tree is ordered by position, so it is split and joined by
+// position in the same way that SplitByPosition and JoinExclusive do. The arithmetic is given by
+// the user: zero is the neutral value, add(a, b) returns a + b and times(delta, n) returns delta
+// added n times
+type LazyTreap struct {
+	randGenerator *rand.Rand
+	null          *lazyNode
+	root          *lazyNode
+	add           func(a, b interface{}) interface{}
+	times         func(delta interface{}, n int) interface{}
+}
+
+// NewLazy Create a new empty sequence with a random generator set to seed
+func NewLazy(seed int64, zero interface{}, add func(a, b interface{}) interface{},
+	times func(delta interface{}, n int) interface{}) *LazyTreap {
+
+	null := &lazyNode{priority: math.MaxUint64, sum: zero}
+	null.llink, null.rlink = null, null
+
+	return &LazyTreap{
+		randGenerator: rand.New(rand.NewSource(seed)),
+		null:          null,
+		root:          null,
+		add:           add,
+		times:         times,
+	}
+}
+
+// NewLazyTreap Create a new empty sequence with random seed chosen from system clock
+func NewLazyTreap(zero interface{}, add func(a, b interface{}) interface{},
+	times func(delta interface{}, n int) interface{}) *LazyTreap {
+	return NewLazy(time.Now().UTC().UnixNano(), zero, add, times)
+}
+
+// Add delta to every value of the subtree p. Only p is updated; its children receive delta
+// when p is pushed
+func (tree *LazyTreap) apply(p *lazyNode, delta interface{}) {
+
+	if p == tree.null {
+		return
+	}
+
+	p.value = tree.add(p.value, delta)
+	p.sum = tree.add(p.sum, tree.times(delta, p.count))
+	if p.pending == nil {
+		p.pending = delta
+	} else {
+		p.pending = tree.add(p.pending, delta)
+	}
+}
+
+// Transfer the pending increment of p to its children
+func (tree *LazyTreap) push(p *lazyNode) {
+
+	if p.pending == nil {
+		return
+	}
+
+	tree.apply(p.llink, p.pending)
+	tree.apply(p.rlink, p.pending)
+	p.pending = nil
+}
+
+// Recompute count and sum of p from its children
+func (tree *LazyTreap) update(p *lazyNode) {
+	p.count = p.llink.count + 1 + p.rlink.count
+	p.sum = tree.add(tree.add(p.llink.sum, p.value), p.rlink.sum)
+}
+
+// Helper that splits root in l with the first i values and r with the remaining ones
+func (tree *LazyTreap) __split(root *lazyNode, i int) (l, r *lazyNode) {
+
+	if root == tree.null {
+		return tree.null, tree.null
+	}
+
+	tree.push(root)
+	if i <= root.llink.count {
+		l, root.llink = tree.__split(root.llink, i)
+		r = root
+	} else {
+		root.rlink, r = tree.__split(root.rlink, i-(root.llink.count+1))
+		l = root
+	}
+	tree.update(root)
+
+	return
+}
+
+// Helper that joins the sequences l and r; the values of l precede those of r
+func (tree *LazyTreap) __join(l, r *lazyNode) *lazyNode {
+
+	if l == tree.null {
+		return r
+	}
+
+	if r == tree.null {
+		return l
+	}
+
+	if l.priority < r.priority {
+		tree.push(l)
+		l.rlink = tree.__join(l.rlink, r)
+		tree.update(l)
+		return l
+	}
+
+	tree.push(r)
+	r.llink = tree.__join(l, r.llink)
+	tree.update(r)
+	return r
+}
+
+// Size Return the number of values of the sequence
+func (tree *LazyTreap) Size() int { return tree.root.count }
+
+// IsEmpty Return true if the sequence is empty
+func (tree *LazyTreap) IsEmpty() bool { return tree.root == tree.null }
+
+// InsertAt Insert value at the position pos, so that the values from pos on are shifted one
+// position. pos can be Size(), in which case value is appended. Panic if pos is out of range
+func (tree *LazyTreap) InsertAt(pos int, value interface{}) {
+
+	if pos < 0 || pos > tree.Size() {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+
+	p := &lazyNode{
+		value:    value,
+		priority: tree.randGenerator.Uint64(),
+		llink:    tree.null,
+		rlink:    tree.null,
+	}
+	tree.update(p)
+
+	l, r := tree.__split(tree.root, pos)
+	tree.root = tree.__join(tree.__join(l, p), r)
+}
+
+// Append Insert the values at the end of the sequence
+func (tree *LazyTreap) Append(values ...interface{}) {
+	for _, value := range values {
+		tree.InsertAt(tree.Size(), value)
+	}
+}
+
+// Helper that panics if [beginPos, endPos] is not a valid range
+func (tree *LazyTreap) checkRange(beginPos, endPos int) {
+	if beginPos < 0 || beginPos > endPos || endPos >= tree.Size() {
+		panic(fmt.Sprintf("Invalid positions %d %d respect to number of values %d",
+			beginPos, endPos, tree.Size()))
+	}
+}
+
+// Helper that splits the tree in the values before beginPos, the values in [beginPos, endPos]
+// and the values after endPos
+func (tree *LazyTreap) splitRange(beginPos, endPos int) (l, m, r *lazyNode) {
+	l, r = tree.__split(tree.root, beginPos)
+	m, r = tree.__split(r, endPos-beginPos+1)
+	return
+}
+
+// AddToRange Add delta to every value whose position is in [beginPos, endPos]. The operation
+// spends O(log n) expected time. Panic if the range is invalid
+func (tree *LazyTreap) AddToRange(beginPos, endPos int, delta interface{}) {
+
+	tree.checkRange(beginPos, endPos)
+
+	l, m, r := tree.splitRange(beginPos, endPos)
+	tree.apply(m, delta)
+	tree.root = tree.__join(tree.__join(l, m), r)
+}
+
+// RangeSum Return the sum of the values whose position is in [beginPos, endPos]. The operation
+// spends O(log n) expected time. Panic if the range is invalid
+func (tree *LazyTreap) RangeSum(beginPos, endPos int) interface{} {
+
+	tree.checkRange(beginPos, endPos)
+
+	l, m, r := tree.splitRange(beginPos, endPos)
+	sum := m.sum
+	tree.root = tree.__join(tree.__join(l, m), r)
+
+	return sum
+}
+
+// Sum Return in O(1) the sum of all the values; zero if the sequence is empty
+func (tree *LazyTreap) Sum() interface{} { return tree.root.sum }
+
+// Get Return the value at the position pos. Panic if pos is out of range
+func (tree *LazyTreap) Get(pos int) interface{} {
+
+	if pos < 0 || pos >= tree.Size() {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+
+	root := tree.root
+	for {
+		tree.push(root)
+		if pos == root.llink.count {
+			return root.value
+		}
+		if pos < root.llink.count {
+			root = root.llink
+		} else {
+			pos -= root.llink.count + 1
+			root = root.rlink
+		}
+	}
+}
+
+// Helper that appends to values the values of p in order, pushing the pending increments
+func (tree *LazyTreap) __toSlice(p *lazyNode, values []interface{}) []interface{} {
+
+	if p == tree.null {
+		return values
+	}
+
+	tree.push(p)
+	values = tree.__toSlice(p.llink, values)
+	values = append(values, p.value)
+	return tree.__toSlice(p.rlink, values)
+}
+
+// ToSlice Return the values of the sequence in order
+func (tree *LazyTreap) ToSlice() []interface{} {
+	return tree.__toSlice(tree.root, make([]interface{}, 0, tree.Size()))
+}
@@ -0,0 +1,102 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func newIntLazy(seed int64) *LazyTreap {
+	return NewLazy(seed, 0,
+		func(a, b interface{}) interface{} { return a.(int) + b.(int) },
+		func(delta interface{}, n int) interface{} { return delta.(int) * n })
+}
+
+// Helper that verifies heap order, counts and sums of every node of a lazy treap. The sums of the
+// children do not include the pending increment of their parent
+func checkLazy(tree *LazyTreap, p *lazyNode) bool {
+
+	if p == tree.null {
+		return true
+	}
+
+	if p.llink.priority < p.priority || p.rlink.priority < p.priority {
+		return false
+	}
+	if p.count != p.llink.count+1+p.rlink.count {
+		return false
+	}
+	pending := 0
+	if p.pending != nil {
+		pending = p.pending.(int)
+	}
+	if p.sum != p.llink.sum.(int)+p.value.(int)+p.rlink.sum.(int)+pending*(p.count-1) {
+		return false
+	}
+
+	return checkLazy(tree, p.llink) && checkLazy(tree, p.rlink)
+}
+
+func TestLazyTreap_AddToRange(t *testing.T) {
+
+	const N = 300
+	tree := newIntLazy(1)
+	values := make([]int, 0, N)
+	for i := 0; i < N; i++ {
+		value := rand.Intn(100)
+		pos := rand.Intn(len(values) + 1)
+		tree.InsertAt(pos, value)
+		values = append(values[:pos], append([]int{value}, values[pos:]...)...)
+	}
+	assert.True(t, checkLazy(tree, tree.root))
+
+	for i := 0; i < 2000; i++ {
+		b := rand.Intn(N)
+		e := b + rand.Intn(N-b)
+		if rand.Intn(2) == 0 {
+			delta := rand.Intn(21) - 10
+			tree.AddToRange(b, e, delta)
+			for j := b; j <= e; j++ {
+				values[j] += delta
+			}
+		} else {
+			sum := 0
+			for j := b; j <= e; j++ {
+				sum += values[j]
+			}
+			assert.Equal(t, sum, tree.RangeSum(b, e), "sum of [%d, %d]", b, e)
+		}
+	}
+
+	assert.True(t, checkLazy(tree, tree.root))
+	for i := 0; i < N; i += 7 {
+		assert.Equal(t, values[i], tree.Get(i))
+	}
+	expected := make([]interface{}, N)
+	total := 0
+	for i, value := range values {
+		expected[i] = value
+		total += value
+	}
+	assert.Equal(t, total, tree.Sum())
+	assert.Equal(t, expected, tree.ToSlice())
+}
+
+func TestLazyTreap_Basics(t *testing.T) {
+
+	tree := newIntLazy(2)
+	assert.True(t, tree.IsEmpty())
+	assert.Equal(t, 0, tree.Sum())
+	assert.Panics(t, func() { tree.RangeSum(0, 0) })
+	assert.Panics(t, func() { tree.InsertAt(1, 5) })
+
+	tree.Append(1, 2, 3, 4)
+	tree.InsertAt(0, 10)
+	assert.Equal(t, []interface{}{10, 1, 2, 3, 4}, tree.ToSlice())
+	tree.AddToRange(1, 3, 100)
+	assert.Equal(t, []interface{}{10, 101, 102, 103, 4}, tree.ToSlice())
+	assert.Equal(t, 205, tree.RangeSum(2, 3))
+	assert.Equal(t, 5, tree.Size())
+	assert.Panics(t, func() { tree.AddToRange(3, 2, 1) })
+	assert.Panics(t, func() { tree.Get(5) })
+}
@@ -0,0 +1,78 @@
+package treaps
+
+// MergeIterator Iterator on the union of two treaps in ascending order. It advances an iterator
+// on every tree and yields the smaller of both current keys, so the union is not built. If dedup
+// is set, then every distinct key is yielded once; otherwise every key of both trees is yielded,
+// the keys of the first tree before the equal keys of the second one. Both trees must share the
+// order and they must not be modified while the iterator is used
+type MergeIterator struct {
+	it1   *Iterator
+	it2   *Iterator
+	less  func(i1, i2 interface{}) bool
+	dedup bool
+	curr  *Iterator // iterator holding the current key; nil if there is not current key
+}
+
+// NewMergeIterator Return an iterator positioned on the smallest key of the union of a and b
+func NewMergeIterator(a, b *Treap, dedup bool) *MergeIterator {
+	it := &MergeIterator{
+		it1:   NewIterator(a),
+		it2:   NewIterator(b),
+		less:  a.Less,
+		dedup: dedup,
+	}
+	it.choose()
+	return it
+}
+
+// Set curr to the iterator whose current key is the smaller one. On ties, the first one is chosen
+func (it *MergeIterator) choose() {
+	switch {
+	case it.it1.HasCurr() && it.it2.HasCurr():
+		if it.less(it.it2.GetCurr(), it.it1.GetCurr()) {
+			it.curr = it.it2
+		} else {
+			it.curr = it.it1
+		}
+	case it.it1.HasCurr():
+		it.curr = it.it1
+	case it.it2.HasCurr():
+		it.curr = it.it2
+	default:
+		it.curr = nil
+	}
+}
+
+// HasCurr Return true if the iterator is positioned on a key
+func (it *MergeIterator) HasCurr() bool { return it.curr != nil }
+
+// GetCurr Return the current key. Panic if there is not current key
+func (it *MergeIterator) GetCurr() interface{} {
+	if it.curr == nil {
+		panic("Iterator has not current item")
+	}
+	return it.curr.GetCurr()
+}
+
+// Next Advance the iterator to the next key of the union. Panic if there is not current key
+func (it *MergeIterator) Next() *MergeIterator {
+	if it.curr == nil {
+		panic("Iterator overflow")
+	}
+
+	if !it.dedup {
+		it.curr.Next()
+		it.choose()
+		return it
+	}
+
+	key := it.curr.GetCurr()
+	for _, source := range []*Iterator{it.it1, it.it2} {
+		for source.HasCurr() && __equal(key, source.GetCurr(), it.less) {
+			source.Next()
+		}
+	}
+	it.choose()
+
+	return it
+}
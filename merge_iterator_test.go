@@ -0,0 +1,67 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func mergeIteratorKeys(it *MergeIterator) []interface{} {
+	keys := make([]interface{}, 0)
+	for ; it.HasCurr(); it.Next() {
+		keys = append(keys, it.GetCurr())
+	}
+	return keys
+}
+
+func TestMergeIterator(t *testing.T) {
+
+	a, b := New(1, cmpInt), New(2, cmpInt)
+	all := make([]int, 0)
+	distinct := make(map[int]bool)
+	for i := 0; i < 500; i++ {
+		key := rand.Intn(300)
+		all = append(all, key)
+		distinct[key] = true
+		if i%3 == 0 {
+			a.InsertDup(key)
+		} else {
+			b.InsertDup(key)
+		}
+	}
+	sort.Ints(all)
+
+	keys := mergeIteratorKeys(NewMergeIterator(a, b, false))
+	assert.Equal(t, len(all), len(keys))
+	for i, key := range all {
+		assert.Equal(t, key, keys[i])
+	}
+
+	keys = mergeIteratorKeys(NewMergeIterator(a, b, true))
+	assert.Equal(t, len(distinct), len(keys))
+	assert.True(t, sort.SliceIsSorted(keys, func(i, j int) bool { return keys[i].(int) < keys[j].(int) }))
+	assert.Equal(t, keys, mergeIteratorKeys(NewMergeIterator(b, a, true)))
+
+	assert.Equal(t, 167, a.Size(), "the sources are not modified")
+}
+
+func TestMergeIterator_Edges(t *testing.T) {
+
+	empty := NewTreap(cmpInt)
+	it := NewMergeIterator(empty, empty, false)
+	assert.False(t, it.HasCurr())
+	assert.Panics(t, func() { it.GetCurr() })
+	assert.Panics(t, func() { it.Next() })
+
+	type pair struct{ key, value int }
+	less := func(i1, i2 interface{}) bool { return i1.(pair).key < i2.(pair).key }
+	a := New(1, less, pair{1, 1}, pair{3, 1})
+	b := New(1, less, pair{1, 2}, pair{2, 2})
+	assert.Equal(t, []interface{}{pair{1, 1}, pair{1, 2}, pair{2, 2}, pair{3, 1}},
+		mergeIteratorKeys(NewMergeIterator(a, b, false)), "ties yield the first tree first")
+	assert.Equal(t, []interface{}{pair{1, 1}, pair{2, 2}, pair{3, 1}},
+		mergeIteratorKeys(NewMergeIterator(a, b, true)))
+	assert.Equal(t, []interface{}{pair{1, 1}, pair{3, 1}},
+		mergeIteratorKeys(NewMergeIterator(a, empty, false)))
+}
@@ -0,0 +1,245 @@
+package treaps
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// PersistentTreap An immutable version of a treap. Insert and Remove do not modify the receiver;
+// they return a new version which shares with the receiver all the nodes that are not on the
+// modified path. So, every operation copies O(log n) expected nodes and all the old versions
+// remain fully usable
+type PersistentTreap struct {
+	root          *Node
+	randGenerator *rand.Rand // shared by all the versions derived from the same tree
+	Less          func(i1, i2 interface{}) bool
+}
+
+// NewPersistent Create a new empty persistent treap with a random generator set to seed and
+// comparison function less
+func NewPersistent(seed int64, less func(i1, i2 interface{}) bool) *PersistentTreap {
+	return &PersistentTreap{
+		root:          nullNodePtr,
+		randGenerator: rand.New(rand.NewSource(seed)),
+		Less:          less,
+	}
+}
+
+// NewPersistentTreap Create a new empty persistent treap with random seed chosen from system clock
+func NewPersistentTreap(less func(i1, i2 interface{}) bool) *PersistentTreap {
+	return NewPersistent(time.Now().UTC().UnixNano(), less)
+}
+
+// Return a new version of tree whose root is root
+func (tree *PersistentTreap) version(root *Node) *PersistentTreap {
+	return &PersistentTreap{
+		root:          root,
+		randGenerator: tree.randGenerator,
+		Less:          tree.Less,
+	}
+}
+
+// Helper that returns a shallow copy of p. Children are shared
+func __cloneNode(p *Node) *Node {
+	q := *p
+	q.allocated = false
+	return &q
+}
+
+// Helper for inserting p into tree root without modifying any of its nodes. The nodes on the
+// search path are copied. Return the new root and false if the key is already in tree
+func __persistentInsert(root, p *Node, less func(i1, i2 interface{}) bool) (*Node, bool) {
+
+	if root == nullNodePtr {
+		return p, true
+	}
+
+	if less(p.key, root.key) {
+		l, ok := __persistentInsert(root.llink, p, less)
+		if !ok {
+			return root, false
+		}
+		q := __cloneNode(root)
+		q.llink = l
+		q.count++
+		if l.priority < q.priority {
+			q = rotateRight(q) // only q and l, both new, are modified
+		}
+		return q, true
+	}
+
+	if less(root.key, p.key) {
+		r, ok := __persistentInsert(root.rlink, p, less)
+		if !ok {
+			return root, false
+		}
+		q := __cloneNode(root)
+		q.rlink = r
+		q.count++
+		if r.priority < q.priority {
+			q = rotateLeft(q)
+		}
+		return q, true
+	}
+
+	return root, false // key is already in tree ==> insertion fails
+}
+
+// Helper that joins two range-disjoint trees without modifying them. The nodes on the right
+// spine of tsRoot and on the left spine of tgRoot that are visited are copied
+func __persistentJoin(tsRoot, tgRoot *Node) *Node {
+
+	if tsRoot == nullNodePtr {
+		return tgRoot
+	}
+
+	if tgRoot == nullNodePtr {
+		return tsRoot
+	}
+
+	if tsRoot.priority < tgRoot.priority {
+		q := __cloneNode(tsRoot)
+		q.count += tgRoot.count
+		q.rlink = __persistentJoin(tsRoot.rlink, tgRoot)
+		return q
+	}
+
+	q := __cloneNode(tgRoot)
+	q.count += tsRoot.count
+	q.llink = __persistentJoin(tsRoot, tgRoot.llink)
+	return q
+}
+
+// Helper for removing key from tree root without modifying any of its nodes. Return the new
+// root and false if key is not found
+func __persistentRemove(root *Node, key interface{},
+	less func(i1, i2 interface{}) bool) (*Node, bool) {
+
+	if root == nullNodePtr {
+		return root, false
+	}
+
+	if less(key, root.key) {
+		l, ok := __persistentRemove(root.llink, key, less)
+		if !ok {
+			return root, false
+		}
+		q := __cloneNode(root)
+		q.llink = l
+		q.count--
+		return q, true
+	}
+
+	if less(root.key, key) {
+		r, ok := __persistentRemove(root.rlink, key, less)
+		if !ok {
+			return root, false
+		}
+		q := __cloneNode(root)
+		q.rlink = r
+		q.count--
+		return q, true
+	}
+
+	return __persistentJoin(root.llink, root.rlink), true // key found
+}
+
+// Insert Return a new version containing item. If item is already contained, then the receiver
+// is returned
+func (tree *PersistentTreap) Insert(item interface{}) *PersistentTreap {
+
+	p := &Node{
+		key:      item,
+		priority: tree.randGenerator.Uint64(),
+		count:    1,
+		llink:    nullNodePtr,
+		rlink:    nullNodePtr,
+	}
+
+	root, ok := __persistentInsert(tree.root, p, tree.Less)
+	if !ok {
+		return tree
+	}
+
+	return tree.version(root)
+}
+
+// Remove Return a new version without key. If key is not contained, then the receiver is returned
+func (tree *PersistentTreap) Remove(key interface{}) *PersistentTreap {
+
+	root, ok := __persistentRemove(tree.root, key, tree.Less)
+	if !ok {
+		return tree
+	}
+
+	return tree.version(root)
+}
+
+// Search in tree key. If key is found, then the value contained in the set is returned.
+// Otherwise, the key was not found, nil value is returned
+func (tree *PersistentTreap) Search(key interface{}) interface{} {
+
+	p := __search(tree.root, key, __cmpFromLess(tree.Less))
+	if p == nullNodePtr {
+		return nil
+	}
+
+	return p.key
+}
+
+// Has Return true if key is found in tree
+func (tree *PersistentTreap) Has(key interface{}) bool {
+	return tree.Search(key) != nil
+}
+
+// Size Return in O(1) the number of keys contained in the tree
+func (tree *PersistentTreap) Size() int { return tree.root.count }
+
+// IsEmpty Return true is set is empty
+func (tree *PersistentTreap) IsEmpty() bool { return tree.root == nullNodePtr }
+
+// Choose Return the key located in the position pos respect to the order of the keys.
+// Panic if pos is out of range
+func (tree *PersistentTreap) Choose(pos int) interface{} {
+
+	if pos < 0 || pos >= tree.Size() {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+
+	return __choose(tree.root, pos).key
+}
+
+// RankInOrder Return (true, pos) if key is found, where pos is its position respect to the order
+// of the keys. Otherwise, it returns (false, Undetermined)
+func (tree *PersistentTreap) RankInOrder(key interface{}) (ok bool, pos int) {
+
+	pos = __rank(tree.root, key, __cmpFromLess(tree.Less))
+	ok = pos != notFound
+	return
+}
+
+// Traverse inorder the whole set and execute operation on each key. The function stops if
+// operation returns false. Return true if all the set was traversed, false otherwise
+func (tree *PersistentTreap) Traverse(operation func(key interface{}) bool) bool {
+
+	stack := __pushLeftPath(make([]iteratorFrame, 0, 64), tree.root, false)
+	for len(stack) > 0 {
+		p := stack[len(stack)-1].node
+		if !operation(p.key) {
+			return false
+		}
+		stack = __pushLeftPath(stack[:len(stack)-1], p.rlink, false)
+	}
+
+	return true
+}
+
+// ToTreap Return a mutable treap with a copy of the keys of this version
+func (tree *PersistentTreap) ToTreap() *Treap {
+
+	ret := NewTreap(tree.Less)
+	*ret.rootPtr = __copy(tree.root, ret.allocNode)
+
+	return ret
+}
@@ -0,0 +1,72 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestPersistentTreap_Versions(t *testing.T) {
+
+	const N = 1000
+	versions := []*PersistentTreap{NewPersistent(1, cmpInt)}
+	keys := rand.Perm(N)
+	for _, key := range keys {
+		versions = append(versions, versions[len(versions)-1].Insert(key))
+	}
+
+	// every version i contains exactly the first i keys
+	for i, version := range versions {
+		assert.Equal(t, i, version.Size())
+		assert.True(t, checkAll(version.root, cmpInt))
+	}
+	for i := 0; i < N; i += 97 {
+		version := versions[i]
+		for j, key := range keys {
+			assert.Equal(t, j < i, version.Has(key))
+		}
+	}
+
+	last := versions[N]
+	assert.Equal(t, last, last.Insert(keys[0]), "inserting a present key returns the same version")
+	assert.Equal(t, last, last.Remove(-1), "removing an absent key returns the same version")
+
+	current := last
+	for _, key := range keys[:N/2] {
+		current = current.Remove(key)
+		assert.True(t, checkAll(current.root, cmpInt))
+	}
+	assert.Equal(t, N/2, current.Size())
+	assert.Equal(t, N, last.Size())
+	assert.True(t, checkAll(last.root, cmpInt))
+	for _, key := range keys {
+		assert.True(t, last.Has(key))
+	}
+}
+
+func TestPersistentTreap_Queries(t *testing.T) {
+
+	tree := NewPersistentTreap(cmpInt)
+	for i := 9; i >= 0; i-- {
+		tree = tree.Insert(i)
+	}
+
+	assert.Equal(t, 3, tree.Choose(3))
+	ok, pos := tree.RankInOrder(7)
+	assert.True(t, ok)
+	assert.Equal(t, 7, pos)
+	assert.Panics(t, func() { tree.Choose(10) })
+
+	acu := 0
+	assert.True(t, tree.Traverse(func(key interface{}) bool {
+		acu = acu*10 + key.(int)
+		return true
+	}))
+	assert.Equal(t, 123456789, acu)
+
+	mutable := tree.ToTreap()
+	assert.True(t, mutable.check())
+	mutable.Remove(5)
+	assert.True(t, tree.Has(5))
+	assert.True(t, checkAll(tree.root, cmpInt))
+}
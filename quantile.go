@@ -0,0 +1,102 @@
+package treaps
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// QuantileSketch Estimator of the quantiles of an unbounded stream of samples that uses O(k)
+// memory. It keeps in a treap a uniform random sample of at most k of the samples seen so far,
+// maintained through reservoir sampling, and answers the quantiles of the retained sample, which
+// are exact while no more than k samples were added.
+//
+// Error bound: by the Dvoretzky-Kiefer-Wolfowitz inequality, with probability at least 1 - delta
+// the rank of every answered quantile differs from the requested one by at most
+// eps = sqrt(ln(2 / delta) / (2 k)) times the number of samples seen. For example, k = 10000
+// gives eps ~ 0.0163 with delta = 0.01; so the p99 estimation is between the true p97.4 and
+// p100. The bound depends on k, not on the length of the stream. See ErrorBound
+type QuantileSketch struct {
+	randGenerator *rand.Rand
+	tree          *Treap
+	capacity      int
+	count         int64 // number of samples seen
+}
+
+// NewQuantileSketch Create an estimator retaining at most capacity samples ordered by less. seed
+// initializes both the treap and the reservoir sampling. Panic if capacity is not positive
+func NewQuantileSketch(seed int64, capacity int, less func(i1, i2 interface{}) bool) *QuantileSketch {
+
+	if capacity <= 0 {
+		panic(fmt.Sprintf("Invalid capacity %d", capacity))
+	}
+
+	return &QuantileSketch{
+		randGenerator: rand.New(rand.NewSource(seed)),
+		tree:          New(seed, less),
+		capacity:      capacity,
+	}
+}
+
+// Add Process sample. While there is room, it is retained. Otherwise, it replaces a random retained
+// sample with probability capacity / Count(), so every sample seen has the same probability of
+// being retained. The operation spends O(log k) expected time
+func (sketch *QuantileSketch) Add(sample interface{}) {
+
+	sketch.count++
+	if sketch.tree.Size() < sketch.capacity {
+		sketch.tree.InsertDup(sample)
+		return
+	}
+
+	if sketch.randGenerator.Int63n(sketch.count) >= int64(sketch.capacity) {
+		return // sample is discarded
+	}
+
+	sketch.tree.RemoveByPos(sketch.randGenerator.Intn(sketch.capacity))
+	sketch.tree.InsertDup(sample)
+}
+
+// Count Return the number of samples seen
+func (sketch *QuantileSketch) Count() int64 { return sketch.count }
+
+// Size Return the number of samples retained, which is at most the capacity
+func (sketch *QuantileSketch) Size() int { return sketch.tree.Size() }
+
+// Quantile Return the estimation of the p quantile, p in [0, 1], that is the retained sample at
+// the position p * Size() of the retained ones. The answer is computed through Choose in
+// O(log k) expected time. Return nil if no sample was added. Panic if p is not in [0, 1]
+func (sketch *QuantileSketch) Quantile(p float64) interface{} {
+
+	if p < 0 || p > 1 {
+		panic(fmt.Sprintf("Invalid quantile %v", p))
+	}
+
+	n := sketch.tree.Size()
+	if n == 0 {
+		return nil
+	}
+
+	pos := int(p * float64(n))
+	if pos == n {
+		pos = n - 1
+	}
+
+	return sketch.tree.Choose(pos)
+}
+
+// ErrorBound Return the maximum rank error, as a fraction of Count(), of the answers of Quantile
+// with probability at least 1 - delta. It is 0 while every sample seen is retained. Panic if delta
+// is not in (0, 1)
+func (sketch *QuantileSketch) ErrorBound(delta float64) float64 {
+
+	if delta <= 0 || delta >= 1 {
+		panic(fmt.Sprintf("Invalid delta %v", delta))
+	}
+
+	if sketch.count <= int64(sketch.capacity) {
+		return 0
+	}
+
+	return math.Sqrt(math.Log(2/delta) / (2 * float64(sketch.capacity)))
+}
@@ -0,0 +1,49 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestQuantileSketch_Exact(t *testing.T) {
+
+	sketch := NewQuantileSketch(1, 1000, cmpInt)
+	assert.Nil(t, sketch.Quantile(0.5))
+
+	for _, v := range rand.New(rand.NewSource(2)).Perm(100) {
+		sketch.Add(v)
+	}
+
+	assert.Equal(t, int64(100), sketch.Count())
+	assert.Equal(t, 100, sketch.Size())
+	assert.Equal(t, 0.0, sketch.ErrorBound(0.01))
+	assert.Equal(t, 0, sketch.Quantile(0))
+	assert.Equal(t, 50, sketch.Quantile(0.5))
+	assert.Equal(t, 99, sketch.Quantile(0.99))
+	assert.Equal(t, 99, sketch.Quantile(1))
+
+	assert.Panics(t, func() { sketch.Quantile(1.5) })
+	assert.Panics(t, func() { sketch.ErrorBound(0) })
+	assert.Panics(t, func() { NewQuantileSketch(1, 0, cmpInt) })
+}
+
+func TestQuantileSketch_Stream(t *testing.T) {
+
+	const n, capacity, delta = 200000, 5000, 0.001
+	sketch := NewQuantileSketch(1, capacity, cmpInt)
+	for _, v := range rand.New(rand.NewSource(3)).Perm(n) {
+		sketch.Add(v)
+	}
+
+	assert.Equal(t, int64(n), sketch.Count())
+	assert.Equal(t, capacity, sketch.Size())
+	assert.True(t, checkAll(*sketch.tree.rootPtr, cmpInt))
+
+	// the values are a permutation of [0, n), so the value is its own rank
+	eps := sketch.ErrorBound(delta)
+	for _, p := range []float64{0.01, 0.25, 0.5, 0.75, 0.99} {
+		rank := float64(sketch.Quantile(p).(int)) / n
+		assert.InDelta(t, p, rank, eps, "quantile %v", p)
+	}
+}
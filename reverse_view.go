@@ -0,0 +1,137 @@
+package treaps
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReverseView A read only view of a treap whose keys are seen in descending order. The view shares
+// the nodes of the tree, so it is built in O(1) and it always reflects the current content of the
+// tree. It does not offer mutating operations; the tree must be modified directly, and, as with
+// any iterator, the iterators of the view must not be used after a modification
+type ReverseView struct {
+	tree *Treap
+}
+
+// ReverseView Return in O(1) a descending view of tree
+func (tree *Treap) ReverseView() *ReverseView {
+	return &ReverseView{tree: tree}
+}
+
+// Tree Return the viewed treap
+func (view *ReverseView) Tree() *Treap { return view.tree }
+
+// Size Return the number of keys of the tree
+func (view *ReverseView) Size() int { return view.tree.Size() }
+
+// IsEmpty Return true if the tree is empty
+func (view *ReverseView) IsEmpty() bool { return view.tree.IsEmpty() }
+
+// Min Return the first key of the view, which is the greatest key of the tree
+func (view *ReverseView) Min() interface{} { return view.tree.Max() }
+
+// Max Return the last key of the view, which is the smallest key of the tree
+func (view *ReverseView) Max() interface{} { return view.tree.Min() }
+
+// Search Return the key of the tree equal to key or nil if it is not found
+func (view *ReverseView) Search(key interface{}) interface{} { return view.tree.Search(key) }
+
+// Has Return true if key is found in the tree
+func (view *ReverseView) Has(key interface{}) bool { return view.tree.Has(key) }
+
+// Choose Return the key at the position pos in descending order; so Choose(0) is the greatest key.
+// Panic if pos is out of range
+func (view *ReverseView) Choose(pos int) interface{} {
+
+	n := view.tree.Size()
+	if pos < 0 || pos >= n {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+
+	return view.tree.Choose(n - 1 - pos)
+}
+
+// RankInOrder Return the position of key in descending order. The pair (false, -1) is returned if
+// key is not in the tree
+func (view *ReverseView) RankInOrder(key interface{}) (ok bool, pos int) {
+
+	ok, pos = view.tree.RankInOrder(key)
+	if ok {
+		pos = view.tree.Size() - 1 - pos
+	}
+	return
+}
+
+// Traverse Execute operation on each key in descending order until operation returns false.
+// Return true if all the keys were visited
+func (view *ReverseView) Traverse(operation func(key interface{}) bool) bool {
+	return view.tree.TraverseReverse(operation)
+}
+
+// String Return the keys in descending order between brackets; e.g. [3 2 1]
+func (view *ReverseView) String() string {
+
+	var builder strings.Builder
+	builder.WriteString("[")
+	for it := view.Iterator(); it.HasCurr(); it.Next() {
+		if it.GetPos() > 0 {
+			builder.WriteString(" ")
+		}
+		builder.WriteString(fmt.Sprint(it.GetCurr()))
+	}
+	builder.WriteString("]")
+
+	return builder.String()
+}
+
+// ReverseIterator Iterator on a ReverseView. Next moves toward smaller keys
+type ReverseIterator struct {
+	it *Iterator
+}
+
+// Iterator Return an iterator positioned on the greatest key of the tree
+func (view *ReverseView) Iterator() *ReverseIterator {
+	return (&ReverseIterator{it: NewIterator(view.tree)}).ResetFirst()
+}
+
+// ResetFirst Reset the iterator to the greatest key
+func (it *ReverseIterator) ResetFirst() *ReverseIterator {
+	if it.it.N > 0 {
+		it.it.ResetLast()
+	}
+	return it
+}
+
+// ResetLast Reset the iterator to the smallest key
+func (it *ReverseIterator) ResetLast() *ReverseIterator {
+	it.it.ResetFirst()
+	return it
+}
+
+// GetPos Return the position of the current key in descending order. It returns -1 if the
+// iterator is before the first key and N if it is past the end
+func (it *ReverseIterator) GetPos() int { return it.it.N - 1 - it.it.GetPos() }
+
+// HasCurr Return true if the iterator is positioned on a key
+func (it *ReverseIterator) HasCurr() bool { return it.it.HasCurr() }
+
+// GetCurr Return the current key. Panic if there is not current key
+func (it *ReverseIterator) GetCurr() interface{} { return it.it.GetCurr() }
+
+// Next Advance the iterator to the next smaller key
+func (it *ReverseIterator) Next() *ReverseIterator {
+	if it.GetPos() == it.it.N {
+		panic("Iterator overflow")
+	}
+	it.it.Prev()
+	return it
+}
+
+// Prev Move the iterator to the next greater key
+func (it *ReverseIterator) Prev() *ReverseIterator {
+	if it.GetPos() == -1 {
+		panic("Iterator underflow")
+	}
+	it.it.Next()
+	return it
+}
@@ -0,0 +1,65 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestReverseView(t *testing.T) {
+
+	tree := New(1, cmpInt, 3, 1, 4, 1, 5, 9, 2, 6)
+	view := tree.ReverseView()
+
+	assert.Equal(t, tree, view.Tree())
+	assert.Equal(t, 8, view.Size())
+	assert.Equal(t, 9, view.Min())
+	assert.Equal(t, 1, view.Max())
+	assert.Equal(t, 9, view.Choose(0))
+	assert.Equal(t, 1, view.Choose(7))
+	assert.Panics(t, func() { view.Choose(8) })
+	assert.Equal(t, "[9 6 5 4 3 2 1 1]", view.String())
+	assert.True(t, view.Has(4))
+	assert.Nil(t, view.Search(7))
+
+	ok, pos := view.RankInOrder(6)
+	assert.True(t, ok)
+	assert.Equal(t, 1, pos)
+	ok, _ = view.RankInOrder(7)
+	assert.False(t, ok)
+
+	keys := make([]interface{}, 0)
+	view.Traverse(func(key interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []interface{}{9, 6, 5, 4, 3, 2, 1, 1}, keys)
+
+	tree.Insert(10) // the view follows the tree
+	assert.Equal(t, 10, view.Choose(0))
+	assert.Equal(t, "[10 9 6 5 4 3 2 1 1]", view.String())
+}
+
+func TestReverseIterator(t *testing.T) {
+
+	tree := New(1, cmpInt, 1, 2, 3)
+	it := tree.ReverseView().Iterator()
+	for i, expected := range []int{3, 2, 1} {
+		assert.True(t, it.HasCurr())
+		assert.Equal(t, i, it.GetPos())
+		assert.Equal(t, expected, it.GetCurr())
+		it.Next()
+	}
+	assert.False(t, it.HasCurr())
+	assert.Equal(t, 3, it.GetPos())
+	assert.Panics(t, func() { it.Next() })
+
+	assert.Equal(t, 1, it.Prev().GetCurr())
+	assert.Equal(t, 2, it.Prev().GetCurr())
+	assert.Equal(t, 1, it.ResetLast().GetCurr())
+	assert.Equal(t, 3, it.ResetFirst().GetCurr())
+	assert.Panics(t, func() { it.Prev().Prev() })
+
+	empty := NewTreap(cmpInt).ReverseView().Iterator()
+	assert.False(t, empty.HasCurr())
+	assert.Equal(t, "[]", NewTreap(cmpInt).ReverseView().String())
+}
@@ -0,0 +1,117 @@
+package treaps
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sequence An array of values indexed by position and implemented through a treap ordered by
+// position instead of by key. Values are never compared, so any value, even incomparable ones,
+// can be stored. Access, insertion and deletion at any position spend O(log n) expected time,
+// since they are done through the positional split and join of the treap
+type Sequence struct {
+	tree *Treap
+}
+
+// NewSequence Create a new sequence containing values in the given order. The random generator
+// is seeded from the system clock
+func NewSequence(values ...interface{}) *Sequence {
+
+	seq := &Sequence{
+		tree: New(time.Now().UTC().UnixNano(), func(i1, i2 interface{}) bool {
+			panic("Values of a sequence are not comparable")
+		}),
+	}
+	seq.Append(values...)
+
+	return seq
+}
+
+// Size Return the number of values
+func (seq *Sequence) Size() int { return seq.tree.Size() }
+
+// IsEmpty Return true if the sequence has not values
+func (seq *Sequence) IsEmpty() bool { return seq.tree.IsEmpty() }
+
+// Helper that panics if pos is not in [0, n)
+func (seq *Sequence) checkPos(pos, n int) {
+	if pos < 0 || pos >= n {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+}
+
+// Insert value at the position pos, so that the values from pos on are shifted one position. pos
+// can be Size(), in which case value is appended. Panic if pos is out of range
+func (seq *Sequence) Insert(pos int, value interface{}) {
+
+	seq.checkPos(pos, seq.Size()+1)
+
+	root := *seq.tree.rootPtr
+	l, r := nullNodePtr, root
+	if pos == root.count {
+		l, r = root, nullNodePtr
+	} else if pos > 0 {
+		l, r = __splitPos(root, pos-1)
+	}
+
+	p := seq.tree.newNode(value)
+	l = __joinExclusive(&l, &p)
+	*seq.tree.rootPtr = __joinExclusive(&l, &r)
+}
+
+// Append Insert values at the end of the sequence
+func (seq *Sequence) Append(values ...interface{}) {
+	for _, value := range values {
+		seq.Insert(seq.Size(), value)
+	}
+}
+
+// DeleteAt Remove and return the value at the position pos. Panic if pos is out of range
+func (seq *Sequence) DeleteAt(pos int) interface{} {
+	seq.checkPos(pos, seq.Size())
+	return seq.tree.RemoveByPos(pos)
+}
+
+// Get Return the value at the position pos. Panic if pos is out of range
+func (seq *Sequence) Get(pos int) interface{} {
+	seq.checkPos(pos, seq.Size())
+	return seq.tree.Choose(pos)
+}
+
+// Helper that returns the pos-th node of the tree pointed by rootPtr after making private the
+// nodes on the path, so that it can be modified
+func __chooseOwned(rootPtr **Node, pos int) *Node {
+
+	for {
+		*rootPtr = __own(*rootPtr)
+		root := *rootPtr
+		__pushReverse(root)
+		if pos == root.llink.count {
+			return root
+		}
+		if pos < root.llink.count {
+			rootPtr = &root.llink
+		} else {
+			pos -= root.llink.count + 1
+			rootPtr = &root.rlink
+		}
+	}
+}
+
+// Set Replace the value at the position pos by value. Panic if pos is out of range
+func (seq *Sequence) Set(pos int, value interface{}) {
+	seq.checkPos(pos, seq.Size())
+	__chooseOwned(seq.tree.rootPtr, pos).key = value
+}
+
+// ReverseRange Reverse the values whose positions are in [beginPos, endPos] in O(log n) expected
+// time. Panic if the range is invalid
+func (seq *Sequence) ReverseRange(beginPos, endPos int) {
+	seq.tree.ReverseRange(beginPos, endPos)
+}
+
+// ToSlice Return the values in order
+func (seq *Sequence) ToSlice() []interface{} { return seq.tree.ToSlice() }
+
+// String Return the values between brackets; e.g. [a b c]
+func (seq *Sequence) String() string { return seq.tree.String() }
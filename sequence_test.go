@@ -0,0 +1,65 @@
+package treaps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestSequence(t *testing.T) {
+
+	seq := NewSequence()
+	reference := make([]interface{}, 0)
+	for step := 0; step < 3000; step++ {
+		n := len(reference)
+		switch op := rand.Intn(10); {
+		case op < 5 || n == 0:
+			pos := rand.Intn(n + 1)
+			value := []int{step} // not comparable through <
+			seq.Insert(pos, value)
+			reference = append(reference[:pos], append([]interface{}{value}, reference[pos:]...)...)
+		case op < 7:
+			pos := rand.Intn(n)
+			assert.Equal(t, reference[pos], seq.DeleteAt(pos))
+			reference = append(reference[:pos], reference[pos+1:]...)
+		case op < 8:
+			pos := rand.Intn(n)
+			seq.Set(pos, []int{-step})
+			reference[pos] = []int{-step}
+		case op < 9:
+			b := rand.Intn(n)
+			e := b + rand.Intn(n-b)
+			seq.ReverseRange(b, e)
+			for i, j := b, e; i < j; i, j = i+1, j-1 {
+				reference[i], reference[j] = reference[j], reference[i]
+			}
+		default:
+			pos := rand.Intn(n)
+			assert.Equal(t, reference[pos], seq.Get(pos))
+		}
+	}
+
+	assert.Equal(t, len(reference), seq.Size())
+	assert.Equal(t, reference, seq.ToSlice())
+	assert.NoError(t, __validateHeap(*seq.tree.rootPtr))
+	assert.NoError(t, __validateCount(*seq.tree.rootPtr))
+}
+
+func TestSequence_Basics(t *testing.T) {
+
+	seq := NewSequence("b", "d")
+	assert.False(t, seq.IsEmpty())
+	seq.Insert(0, "a")
+	seq.Insert(2, "c")
+	seq.Append("e")
+	assert.Equal(t, "[a b c d e]", seq.String())
+	assert.Equal(t, "c", seq.DeleteAt(2))
+	assert.Equal(t, "d", seq.Get(2))
+	seq.Set(0, "z")
+	assert.Equal(t, []interface{}{"z", "b", "d", "e"}, seq.ToSlice())
+
+	assert.Panics(t, func() { seq.Insert(5, "x") })
+	assert.Panics(t, func() { seq.Get(-1) })
+	assert.Panics(t, func() { seq.DeleteAt(4) })
+	assert.True(t, NewSequence().IsEmpty())
+}
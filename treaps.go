@@ -3,21 +3,52 @@
 package treaps
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"math/bits"
 	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
+	"unsafe"
 )
 
 const notFound = -1
 
+// ErrPositionOutOfRange Error returned by the positional methods when a position is not in
+// [0, Size)
+type ErrPositionOutOfRange struct {
+	Pos  int // offending position
+	Size int // number of keys in the tree when the error happened
+}
+
+func (e *ErrPositionOutOfRange) Error() string {
+	return fmt.Sprintf("Position %d out of range [0, %d)", e.Pos, e.Size)
+}
+
+// ErrNotRangeDisjoint Error returned by JoinExclusiveE when the maximum key of the smaller tree is
+// not less than the minimum key of the greater tree
+type ErrNotRangeDisjoint struct {
+	Max interface{} // maximum key of the tree expected to be smaller
+	Min interface{} // minimum key of the tree expected to be greater
+}
+
+func (e *ErrNotRangeDisjoint) Error() string {
+	return fmt.Sprintf("Trees are not range-disjoint: %v is not less than %v", e.Max, e.Min)
+}
+
 // Node The structure of every node
 type Node struct {
-	key      interface{} // generic key
-	priority uint64      // priority value for heap order balancing
-	count    int         // number of nodes that I, as tree, contain
-	llink    *Node       // left child pointer
-	rlink    *Node       // right child pointer
+	key       interface{} // generic key
+	priority  uint64      // priority value for heap order balancing
+	count     int         // number of nodes that I, as tree, contain
+	llink     *Node       // left child pointer
+	rlink     *Node       // right child pointer
+	shared    bool        // true if the node could be reachable from more than one tree
+	reversed  bool        // true if the subtree must be read mirrored. See ReverseRange
+	allocated bool        // true if the node was given by an allocator. See NewWithAllocator
 }
 
 func (p *Node) swap(q *Node) {
@@ -26,12 +57,68 @@ func (p *Node) swap(q *Node) {
 	p.count, q.count = q.count, p.count
 	p.llink, q.llink = q.llink, p.llink
 	p.rlink, q.rlink = q.rlink, p.rlink
+	p.reversed, q.reversed = q.reversed, p.reversed
 }
 
 func (p *Node) reset() {
 	p.llink = nullNodePtr
 	p.rlink = nullNodePtr
 	p.count = 1
+	p.reversed = false
+}
+
+// Helper that returns a node that can be modified in place instead of p. If p is shared with
+// other tree, then a private copy of p is returned and the children of p become shared, since
+// now they have two parents. Mutating helpers must call it while descending from the root, so
+// that the whole modified path becomes private
+func __own(p *Node) *Node {
+
+	if !p.shared {
+		return p
+	}
+
+	q := *p
+	q.shared = false
+	q.allocated = false
+	if p.llink != nullNodePtr {
+		p.llink.shared = true
+	}
+	if p.rlink != nullNodePtr {
+		p.rlink.shared = true
+	}
+
+	return &q
+}
+
+// Helper that applies the pending reversal of p, which must be private: its children are swapped
+// and the reversal is passed to them. Mutating helpers that descend by position must call it
+// after __own
+func __pushReverse(p *Node) {
+
+	if !p.reversed {
+		return
+	}
+
+	p.llink, p.rlink = p.rlink, p.llink
+	if p.llink != nullNodePtr {
+		p.llink = __own(p.llink)
+		p.llink.reversed = !p.llink.reversed
+	}
+	if p.rlink != nullNodePtr {
+		p.rlink = __own(p.rlink)
+		p.rlink.reversed = !p.rlink.reversed
+	}
+	p.reversed = false
+}
+
+// Helper that returns the children of p in the order in which they must be read. rev tells
+// whether an ancestor of p has a pending reversal. The returned flag must be passed as rev for
+// the children
+func __children(p *Node, rev bool) (l, r *Node, childRev bool) {
+	if rev != p.reversed {
+		return p.rlink, p.llink, true
+	}
+	return p.llink, p.rlink, false
 }
 
 // This node, supposed to be immutable, represents the empty tree, as well as an
@@ -53,6 +140,39 @@ type Treap struct {
 	head          Node // header node dummy parent of rootPtr
 	headPtr       *Node
 	Less          func(i1, i2 interface{}) bool
+	cmp           func(i1, i2 interface{}) int // three-way version of Less
+	threeWay      bool                         // true if cmp was given by the user. See NewWithCmp
+	priorityFunc  func(key interface{}) uint64 // if not nil, it replaces randGenerator
+	pooled        bool                         // if true, removed nodes are kept for reuse
+	freeList      []*Node                      // nodes available for the next insertions
+	onInsert      []func(key interface{})      // observers of Insert and InsertDup
+	onRemove      []func(key interface{})      // observers of Remove
+	keyType       reflect.Type                 // if not nil, the type that every key must have
+	modCount      uint64                       // number of structural modifications. See Iterator
+	alloc         func() *Node                 // if not nil, it provides the new nodes
+	free          func(*Node)                  // if not nil, it receives the released nodes
+}
+
+// Helper that adapts a less function to a three-way comparison. Two calls to less are only
+// spent when the keys are equal or when the first key is greater
+func __cmpFromLess(less func(i1, i2 interface{}) bool) func(i1, i2 interface{}) int {
+	return func(i1, i2 interface{}) int {
+		if less(i1, i2) {
+			return -1
+		}
+		if less(i2, i1) {
+			return 1
+		}
+		return 0
+	}
+}
+
+// Reverse Return the comparison function that orders the keys inversely to less. A treap built
+// with New(seed, Reverse(less)) keeps its keys in descending order respect to less
+func Reverse(less func(i1, i2 interface{}) bool) func(i1, i2 interface{}) bool {
+	return func(i1, i2 interface{}) bool {
+		return less(i2, i1)
+	}
 }
 
 // helper for implementing == with < operation
@@ -77,13 +197,49 @@ func __greaterOrEqual(i1, i2 interface{}, less func(i1, i2 interface{}) bool) bo
 func (tree *Treap) Swap(other interface{}) interface{} {
 
 	rhs := other.(*Treap)
+	tree.modified()
+	rhs.modified()
 	tree.seed, rhs.seed = rhs.seed, tree.seed
 	tree.randGenerator, rhs.randGenerator = rhs.randGenerator, tree.randGenerator
 	*tree.rootPtr, *rhs.rootPtr = *rhs.rootPtr, *tree.rootPtr
 	tree.Less, rhs.Less = rhs.Less, tree.Less
+	tree.cmp, rhs.cmp = rhs.cmp, tree.cmp
+	tree.threeWay, rhs.threeWay = rhs.threeWay, tree.threeWay
+	tree.priorityFunc, rhs.priorityFunc = rhs.priorityFunc, tree.priorityFunc
+	tree.alloc, rhs.alloc = rhs.alloc, tree.alloc
+	tree.free, rhs.free = rhs.free, tree.free
 	return tree
 }
 
+// SetPriorityFunc Set a function that computes the priority of every key subsequently inserted.
+// It allows to build reproducible trees. If f is nil, then the priorities are again drawn from
+// the random generator
+func (tree *Treap) SetPriorityFunc(f func(key interface{}) uint64) {
+	tree.priorityFunc = f
+}
+
+// Seed Return the seed of the random generator of the tree
+func (tree *Treap) Seed() int64 { return tree.seed }
+
+// ReSeed Install a new random generator set to seed. Only the priorities of the keys subsequently
+// inserted are affected; the nodes already in the tree keep theirs. It allows, for example, to make
+// the growth of a copy independent of that of the original tree
+func (tree *Treap) ReSeed(seed int64) {
+	tree.seed = seed
+	tree.randGenerator = rand.New(rand.NewSource(seed))
+}
+
+// Register a structural modification, so that the iterators created before it are invalidated
+func (tree *Treap) modified() { tree.modCount++ }
+
+// Return the priority for a new node containing key
+func (tree *Treap) newPriority(key interface{}) uint64 {
+	if tree.priorityFunc != nil {
+		return tree.priorityFunc(key)
+	}
+	return tree.randGenerator.Uint64()
+}
+
 // New Create a new treap with a random generator set to seed and comparison function less
 func New(seed int64, less func(i1, i2 interface{}) bool, items ...interface{}) *Treap {
 
@@ -92,6 +248,7 @@ func New(seed int64, less func(i1, i2 interface{}) bool, items ...interface{}) *
 		seed:          seed,
 		randGenerator: rand.New(src),
 		Less:          less,
+		cmp:           __cmpFromLess(less),
 	}
 
 	tree.head.llink = nullNodePtr
@@ -106,315 +263,959 @@ func New(seed int64, less func(i1, i2 interface{}) bool, items ...interface{}) *
 	return tree
 }
 
-// Clear Empty the set
-func (tree *Treap) Clear() {
-	*tree.rootPtr = nullNodePtr
-}
+// NewPooled Create a new treap as New does, but it keeps a free list of nodes. The nodes
+// released by Remove, RemoveByPos, ExtractMin, ExtractMax and Clear are reused by the next
+// insertions, which saves allocations in loops that repeatedly fill and empty the set. Nodes
+// shared with a snapshot are never recycled. Iterators must not be used across a removal
+func NewPooled(seed int64, less func(i1, i2 interface{}) bool, items ...interface{}) *Treap {
 
-// IsEmpty Return true is set is empty
-func (tree *Treap) IsEmpty() bool { return *tree.rootPtr == nullNodePtr }
+	tree := New(seed, less)
+	tree.pooled = true
 
-// NewTreap Create a new tree with random seed chosen from system clock
-func NewTreap(less func(i1, i2 interface{}) bool, items ...interface{}) *Treap {
-	return New(time.Now().UTC().UnixNano(), less, items...)
-}
+	for _, item := range items {
+		tree.InsertDup(item)
+	}
 
-func (tree *Treap) Create(items ...interface{}) interface{} {
-	return New(time.Now().UTC().UnixNano(), tree.Less, items...)
+	return tree
 }
 
-// Helper function that perform an exact topological Copy of tree rooted by p
-func __copy(p *Node) *Node {
+// NewWithAllocator Create a new treap as New does, but its nodes are taken from alloc and the
+// nodes released by Remove, RemoveByPos, ExtractMin, ExtractMax and Clear are given back to free,
+// so that they can come from a user arena. If alloc is nil, then the nodes are allocated in the
+// heap, and if free is nil, then the released nodes are left to the garbage collector. The trees
+// derived from this one, such as the results of splits and set operations, use the same
+// allocator, and the copies made by Copy, DeepCopy, SliceRange and the set operations take their
+// nodes from it too. Only the nodes given by alloc are given back to free: the nodes shared with a
+// snapshot, as well as their copies made on write, are left to the garbage collector. Nodes dropped
+// by ClearAndShrink are not given back to free either
+func NewWithAllocator(seed int64, less func(i1, i2 interface{}) bool, alloc func() *Node,
+	free func(*Node), items ...interface{}) *Treap {
 
-	if p == nullNodePtr {
-		return nullNodePtr
-	}
+	tree := New(seed, less)
+	tree.alloc = alloc
+	tree.free = free
 
-	return &Node{
-		key:      p.key,
-		priority: p.priority,
-		count:    p.count,
-		llink:    __copy(p.llink),
-		rlink:    __copy(p.rlink),
+	for _, item := range items {
+		tree.InsertDup(item)
 	}
+
+	return tree
 }
 
-// Copy Get an exact Copy of tree
-func (tree *Treap) Copy() *Treap {
+// NewTyped Create a new tree, with random seed chosen from system clock, that only accepts keys
+// of the same dynamic type than sample. Inserting a key of other type panics at the insertion,
+// with a message naming both types, instead of inside less
+func NewTyped(sample interface{}, less func(i1, i2 interface{}) bool, items ...interface{}) *Treap {
 
-	ret := New(tree.seed, tree.Less)
-	*ret.rootPtr = __copy(*tree.rootPtr)
+	tree := NewTreap(less)
+	tree.keyType = reflect.TypeOf(sample)
 
-	return ret
+	for _, item := range items {
+		tree.InsertDup(item)
+	}
+
+	return tree
 }
 
-// Helper for topological comparison of two trees
-func __topologicalEqual(t1, t2 *Node, less func(i1, i2 interface{}) bool) bool {
+// Return a leaf node without key. The node is taken from the free list if there is one
+// available; otherwise from the allocator of the tree, if any, or from the heap
+func (tree *Treap) allocNode() *Node {
 
-	if t1 == nullNodePtr && t2 == nullNodePtr {
-		return true
+	if n := len(tree.freeList); n > 0 {
+		p := tree.freeList[n-1]
+		tree.freeList[n-1] = nil
+		tree.freeList = tree.freeList[:n-1]
+		return p
 	}
 
-	if (t1 == nullNodePtr && t2 != nullNodePtr) || (t1 != nullNodePtr && t2 == nullNodePtr) {
-		return false
+	if tree.alloc != nil {
+		p := tree.alloc()
+		*p = Node{allocated: true}
+		p.reset()
+		return p
 	}
 
-	if !__equal(t1.key, t2.key, less) {
-		return false // keys are different
+	return &Node{
+		count: 1,
+		llink: nullNodePtr,
+		rlink: nullNodePtr,
 	}
-
-	return __topologicalEqual(t1.llink, t2.llink, less) &&
-		__topologicalEqual(t1.rlink, t2.rlink, less)
 }
 
-// Return true if tree is topologically equivalent to rhs
-func (tree *Treap) TopologicalEqual(rhs *Treap) bool {
-	return __topologicalEqual(*tree.rootPtr, *rhs.rootPtr, tree.Less)
+// Panic if the tree was built by NewTyped and item has not the type of its keys
+func (tree *Treap) checkKey(item interface{}) {
+	if tree.keyType != nil && reflect.TypeOf(item) != tree.keyType {
+		panic(fmt.Sprintf("Key %v has type %T, but the tree only accepts keys of type %v",
+			item, item, tree.keyType))
+	}
 }
 
-// Helper for inserting node p into the tree root. BST order is handled through less function
-func __insertNode(root, p *Node, less func(i1, i2 interface{}) bool) *Node {
+// Return a node ready for being inserted with item as key
+func (tree *Treap) newNode(item interface{}) *Node {
 
-	if root == nullNodePtr {
-		return p
-	}
+	tree.checkKey(item)
 
-	resultNode := nullNodePtr
-	if less(p.key, root.key) {
-		resultNode = __insertNode(root.llink, p, less)
-		if resultNode == nullNodePtr { // was p inserted?
-			return nullNodePtr // key is already in tree ==> insertion fails
-		}
+	p := tree.allocNode()
+	p.key = item
+	p.priority = tree.newPriority(item)
 
-		root.llink = resultNode
-		root.count++
-		if resultNode.priority < root.priority {
-			root = rotateRight(root)
-		}
-		return root
+	return p
+}
+
+// Give p back to the allocator of the tree, or put it in the free list if the tree is pooled,
+// provided that p is not reachable from other tree. Only the nodes given by an allocator are
+// given back to free; the others, such as the copies made on write, are left to the garbage
+// collector. Return the key that p contained
+func (tree *Treap) releaseNode(p *Node) interface{} {
+
+	key := p.key
+	if p.shared {
+		return key
 	}
 
-	if less(root.key, p.key) {
-		resultNode = __insertNode(root.rlink, p, less)
-		if resultNode == nullNodePtr { // was p inserted?
-			return nullNodePtr // key is already in tree ==> insertion fails
+	if tree.free != nil {
+		if p.allocated {
+			p.reset()
+			p.key = nil
+			p.priority = 0
+			tree.free(p)
 		}
+		return key
+	}
 
-		root.rlink = resultNode
-		root.count++
-		if resultNode.priority < root.priority {
-			root = rotateLeft(root)
-		}
-		return root
+	if tree.pooled {
+		p.reset()
+		p.key = nil
+		p.priority = 0
+		tree.freeList = append(tree.freeList, p)
 	}
 
-	return nullNodePtr // key is already in tree ==> insertion fails
+	return key
 }
 
-// Insert item into the tree. Return nil if key is already contained; otherwise
-// returns the value of the just inserted item
-func (tree *Treap) Insert(item interface{}) interface{} {
+// Helper that puts in the free list every node of root that is only reachable from tree. A
+// shared node, as well as its whole subtree, is left to the garbage collector
+func (tree *Treap) __releaseTree(root *Node) {
 
-	p := &Node{
-		key:      item,
-		priority: tree.randGenerator.Uint64(),
-		count:    1,
-		llink:    nullNodePtr,
-		rlink:    nullNodePtr,
+	if root == nullNodePtr || root.shared {
+		return
 	}
 
-	result := __insertNode(*tree.rootPtr, p, tree.Less)
-	if result == nullNodePtr {
-		return nil
+	tree.__releaseTree(root.llink)
+	tree.__releaseTree(root.rlink)
+	tree.releaseNode(root)
+}
+
+// Clear Empty the set
+func (tree *Treap) Clear() {
+	tree.modified()
+	if tree.pooled || tree.free != nil {
+		tree.__releaseTree(*tree.rootPtr)
 	}
+	*tree.rootPtr = nullNodePtr
+}
 
-	*tree.rootPtr = result
-	return p.key
+// ClearAndShrink Empty the set and drop every reference to its nodes, including the free list of
+// a pooled tree, so that the garbage collector can reclaim all of them. The random generator is
+// restarted from the seed, so the tree behaves as just returned by New. The configuration is kept:
+// the order, the priority function, the pooling, the key type and the observers
+func (tree *Treap) ClearAndShrink() {
+	tree.modified()
+	*tree.rootPtr = nullNodePtr
+	tree.freeList = nil
+	tree.randGenerator = rand.New(rand.NewSource(tree.seed))
 }
 
-// Append equivalent to insert. Put for supporting functional operations
-func (tree *Treap) Append(item interface{}, items ...interface{}) interface{} {
-	tree.Insert(item)
-	for _, i := range items {
-		tree.Insert(i)
+// IsEmpty Return true is set is empty
+func (tree *Treap) IsEmpty() bool { return *tree.rootPtr == nullNodePtr }
+
+// NewTreap Create a new tree with random seed chosen from system clock
+func NewTreap(less func(i1, i2 interface{}) bool, items ...interface{}) *Treap {
+	return New(time.Now().UTC().UnixNano(), less, items...)
+}
+
+// NewWithCmp Create a new treap with a random generator set to seed and a three-way comparison
+// function cmp, which returns a negative number if i1 < i2, zero if they are equal and a
+// positive number if i1 > i2. Search, Insert, Remove and RankInOrder spend a single call to cmp
+// per visited node. Less is derived from cmp, so it must not be reassigned
+func NewWithCmp(seed int64, cmp func(i1, i2 interface{}) int, items ...interface{}) *Treap {
+
+	tree := New(seed, func(i1, i2 interface{}) bool { return cmp(i1, i2) < 0 })
+	tree.cmp = cmp
+	tree.threeWay = true
+
+	for _, item := range items {
+		tree.InsertDup(item)
 	}
+
 	return tree
 }
 
-// Helper for inserting node p into the tree root. BST order is handled through less function.
-// key stored in p can be already present in the tree,. In this case, The key will be duplicated
-func __insertNodeDup(root, p *Node, less func(i1, i2 interface{}) bool) *Node {
+// NewTreapWithCmp Create a new tree ordered by cmp with random seed chosen from system clock
+func NewTreapWithCmp(cmp func(i1, i2 interface{}) int, items ...interface{}) *Treap {
+	return NewWithCmp(time.Now().UTC().UnixNano(), cmp, items...)
+}
 
-	if root == nullNodePtr {
-		return p
-	}
+// Return a new empty tree with the same order, priority function, key type and allocator than
+// tree, whose random generator is set to seed
+func (tree *Treap) newLike(seed int64) *Treap {
 
-	resultNode := nullNodePtr
-	if less(p.key, root.key) {
-		resultNode = __insertNodeDup(root.llink, p, less)
-		root.llink = resultNode
-		root.count++
-		if resultNode.priority < root.priority {
-			root = rotateRight(root)
-		}
-		return root
-	}
+	ret := New(seed, tree.Less)
+	ret.cmp = tree.cmp
+	ret.threeWay = tree.threeWay
+	ret.priorityFunc = tree.priorityFunc
+	ret.keyType = tree.keyType
+	ret.alloc = tree.alloc
+	ret.free = tree.free
 
-	resultNode = __insertNodeDup(root.rlink, p, less)
-	root.rlink = resultNode
-	root.count++
-	if resultNode.priority < root.priority {
-		root = rotateLeft(root)
-	}
+	return ret
+}
 
-	return root
+func (tree *Treap) Create(items ...interface{}) interface{} {
+	ret := tree.newLike(time.Now().UTC().UnixNano())
+	for _, item := range items {
+		ret.InsertDup(item)
+	}
+	return ret
 }
 
-// Insert item into the tree. Return nil if key is already contained; otherwise
-// returns the value of the just inserted item
-func (tree *Treap) InsertDup(item interface{}) interface{} {
+// Helper function that perform an exact topological Copy of tree rooted by p. The nodes of the
+// copy are taken from alloc
+func __copy(p *Node, alloc func() *Node) *Node {
 
-	p := &Node{
-		key:      item,
-		priority: tree.randGenerator.Uint64(),
-		count:    1,
-		llink:    nullNodePtr,
-		rlink:    nullNodePtr,
+	if p == nullNodePtr {
+		return nullNodePtr
 	}
 
-	result := __insertNodeDup(*tree.rootPtr, p, tree.Less)
+	q := alloc()
+	q.key = p.key
+	q.priority = p.priority
+	q.count = p.count
+	q.llink = __copy(p.llink, alloc)
+	q.rlink = __copy(p.rlink, alloc)
+	q.reversed = p.reversed
 
-	*tree.rootPtr = result
-	return p.key
+	return q
 }
 
-// Search in tree key. If key is found, then the value contained in the set is returned.
-// Otherwise, the key was not found, nil value is returned
-func (tree *Treap) Search(key interface{}) interface{} {
+// Copy Get an exact Copy of tree. The copied nodes keep their priorities, but the copy has its own
+// random generator seeded from the system clock, so further insertions in both trees diverge
+func (tree *Treap) Copy() *Treap {
 
-	root := *tree.rootPtr
-	for root != nullNodePtr {
+	ret := tree.newLike(time.Now().UTC().UnixNano())
+	*ret.rootPtr = __copy(*tree.rootPtr, ret.allocNode)
 
-		if tree.Less(key, root.key) {
-			root = root.llink
-		} else if tree.Less(root.key, key) {
-			root = root.rlink
-		} else {
-			break // key found!
-		}
+	return ret
+}
+
+// Helper that copies p as __copy does, but the keys of the copy are cloneKey(key). Panic if a
+// cloned key is not equal to its original respect to less
+func __deepCopy(p *Node, cloneKey func(key interface{}) interface{},
+	less func(i1, i2 interface{}) bool, alloc func() *Node) *Node {
+
+	if p == nullNodePtr {
+		return nullNodePtr
 	}
 
-	if root == nullNodePtr {
-		return nil
+	key := cloneKey(p.key)
+	if !__equal(key, p.key, less) {
+		panic(fmt.Sprintf("Cloned key %v is not equal to the original key %v", key, p.key))
 	}
 
-	return root.key
+	q := alloc()
+	q.key = key
+	q.priority = p.priority
+	q.count = p.count
+	q.llink = __deepCopy(p.llink, cloneKey, less, alloc)
+	q.rlink = __deepCopy(p.rlink, cloneKey, less, alloc)
+	q.reversed = p.reversed
+
+	return q
 }
 
-// Return true if key is found in tree
-func (tree *Treap) Has(key interface{}) bool {
-	return tree.Search(key) != nil
+// DeepCopy Get a copy of tree as Copy does, but every key of the copy is cloneKey(key), so that
+// pointer keys are not aliased between both trees. The topology and the priorities are kept.
+// Panic if a cloned key is not equal to its original, since the copy would not be ordered
+func (tree *Treap) DeepCopy(cloneKey func(key interface{}) interface{}) *Treap {
+
+	ret := tree.newLike(time.Now().UTC().UnixNano())
+	*ret.rootPtr = __deepCopy(*tree.rootPtr, cloneKey, tree.Less, ret.allocNode)
+
+	return ret
 }
 
-// Helper function for searching a node and eventually Insert it into the tree if it is not found
-func __searchOrInsertNode(root **Node, p *Node, less func(i1, i2 interface{}) bool) *Node {
+// Snapshot Return in O(1) a tree sharing its nodes with tree. The nodes are copied on write: a
+// later modification on any of both trees copies only the nodes on the modified path, so reads
+// on the snapshot are not affected by the changes on tree and vice versa
+func (tree *Treap) Snapshot() *Treap {
 
-	if *root == nullNodePtr {
-		*root = p
-		return p
+	ret := tree.newLike(time.Now().UTC().UnixNano())
+	*ret.rootPtr = *tree.rootPtr
+	if *tree.rootPtr != nullNodePtr {
+		(*tree.rootPtr).shared = true
 	}
 
-	if less(p.key, (*root).key) {
-		ret := __searchOrInsertNode(&(*root).llink, p, less)
-		if ret == p {
-			(*root).count++
-			if ret.priority < (*root).priority {
-				*root = rotateRight(*root)
-			}
-		}
-		return ret
-	}
+	return ret
+}
 
-	if less((*root).key, p.key) {
-		ret := __searchOrInsertNode(&(*root).rlink, p, less)
-		if ret == p {
-			(*root).count++
-			if ret.priority < (*root).priority {
-				*root = rotateLeft(*root)
-			}
-		}
-		return ret
+// Helper that recomputes bottom-up the count field of every node of tree rooted by p
+func __computeCount(p *Node) int {
+
+	if p == nullNodePtr {
+		return 0
 	}
 
-	return *root // key is already in tree ==> insertion fails
+	p.count = __computeCount(p.llink) + 1 + __computeCount(p.rlink)
+	return p.count
 }
 
-// Search in tree item. If it is found, then the pair (false, item-value) is returned.
-// Otherwise, the item is inserted into the tree and the pair (true, item) is returned
-func (tree *Treap) SearchOrInsert(item interface{}) (bool, interface{}) {
+// Helper that appends p, whose key is greater or equal than all the keys already added, to a
+// Cartesian tree under construction, whose right spine is spine. p must be a leaf. Return the new
+// right spine. The root of the final tree is spine[0] and its counts must be computed at the end
+func __appendToSpine(spine []*Node, p *Node) []*Node {
 
-	p := &Node{
-		key:      item,
-		priority: tree.randGenerator.Uint64(),
-		count:    1,
-		llink:    nullNodePtr,
-		rlink:    nullNodePtr,
+	last := nullNodePtr
+	for len(spine) > 0 && spine[len(spine)-1].priority > p.priority {
+		last = spine[len(spine)-1]
+		spine = spine[:len(spine)-1]
 	}
 
-	result := __searchOrInsertNode(tree.rootPtr, p, tree.Less)
-	if result != p {
-		return false, result.key
+	p.llink = last
+	if len(spine) > 0 {
+		spine[len(spine)-1].rlink = p
 	}
 
-	return true, p.key
+	return append(spine, p)
 }
 
-// Helper for removing key from a tree. Returns the removed node if this one is found.
-// Otherwise, nullNodePte is returned.
-func __remove(rootPtr **Node, key interface{}, less func(i1, i2 interface{}) bool) *Node {
+// Helper that returns the root of the Cartesian tree whose right spine is spine
+func __spineRoot(spine []*Node) *Node {
 
-	if *rootPtr == nullNodePtr {
+	if len(spine) == 0 {
 		return nullNodePtr
 	}
 
-	var retVal *Node
-	if less(key, (*rootPtr).key) {
-		retVal = __remove(&(*rootPtr).llink, key, less)
-	} else if less((*rootPtr).key, key) {
-		retVal = __remove(&(*rootPtr).rlink, key, less)
-	} else { // key found
-		retVal = *rootPtr // this node will be deleted
-		*rootPtr = __joinExclusive(&(*rootPtr).llink, &(*rootPtr).rlink)
-		retVal.reset()
-		return retVal
-	}
+	__computeCount(spine[0])
+	return spine[0]
+}
 
-	if retVal == nullNodePtr {
-		return nullNodePtr // key not found
+// Helper that panics if sorted is not in ascending order respect to less
+func __checkSorted(sorted []interface{}, less func(i1, i2 interface{}) bool) {
+	for i := 1; i < len(sorted); i++ {
+		if less(sorted[i], sorted[i-1]) {
+			panic(fmt.Sprintf("Keys at positions %d and %d are out of order", i-1, i))
+		}
 	}
-
-	(*rootPtr).count--
-
-	return retVal
 }
 
-// Remove key from the tree. Return the removed value if the removal was successful.
-// Otherwise, the item was not found and the value nil is returned as signal of the failure
-func (tree *Treap) Remove(key interface{}) interface{} {
+// FromSortedSlice Build in O(n) a treap from the keys of sorted, which must be in ascending order
+// respect to less. Duplicated keys are allowed. The tree is built as a Cartesian tree through a
+// right spine stack, so that heap order of the random priorities is kept without rotations.
+// Panic if a pair of keys is out of order
+func FromSortedSlice(seed int64, less func(i1, i2 interface{}) bool, sorted []interface{}) *Treap {
 
-	retVal := __remove(tree.rootPtr, key, tree.Less)
-	if retVal == nullNodePtr {
-		return nil // key not found
+	__checkSorted(sorted, less)
+
+	tree := New(seed, less)
+	spine := make([]*Node, 0) // right spine of the tree built so far
+	for _, item := range sorted {
+		spine = __appendToSpine(spine, &Node{
+			key:      item,
+			priority: tree.newPriority(item),
+			count:    1,
+			llink:    nullNodePtr,
+			rlink:    nullNodePtr,
+		})
 	}
+	*tree.rootPtr = __spineRoot(spine)
 
-	return retVal.key
+	return tree
 }
 
-func __removePos(rootPtr **Node, i int) *Node {
+// FromSortedChan Build a treap from the keys received from ch, which must arrive in ascending
+// order respect to less, until ch is closed. Duplicated keys are allowed. Every key is appended
+// on the right spine of the tree as FromSortedSlice does, so the keys are not buffered and each
+// one costs O(1) amortized time. Panic if a key is less than its predecessor
+func FromSortedChan(seed int64, less func(i1, i2 interface{}) bool, ch <-chan interface{}) *Treap {
 
-	root := *rootPtr
-	var retVal *Node
-	if i == root.llink.count {
+	tree := New(seed, less)
+	spine := make([]*Node, 0) // right spine of the tree built so far
+	var last interface{}
+	i := 0
+	for item := range ch {
+		if i > 0 && less(item, last) {
+			panic(fmt.Sprintf("Keys at positions %d and %d are out of order", i-1, i))
+		}
+		spine = __appendToSpine(spine, &Node{
+			key:      item,
+			priority: tree.newPriority(item),
+			count:    1,
+			llink:    nullNodePtr,
+			rlink:    nullNodePtr,
+		})
+		last = item
+		i++
+	}
+	*tree.rootPtr = __spineRoot(spine)
+
+	return tree
+}
+
+// Helper that appends to nodes the nodes of root in order. The nodes that could be reachable from
+// other tree, because they or an ancestor are shared, are replaced by private copies
+func __inorderNodes(root *Node, shared bool, nodes []*Node) []*Node {
+
+	if root == nullNodePtr {
+		return nodes
+	}
+
+	shared = shared || root.shared
+	nodes = __inorderNodes(root.llink, shared, nodes)
+	p := root
+	if shared {
+		q := *root
+		q.shared = false
+		q.allocated = false
+		p = &q
+	}
+	nodes = append(nodes, p)
+
+	return __inorderNodes(root.rlink, shared, nodes)
+}
+
+// Helper for BulkInsert and BulkInsertDup. The nodes of tree keep their priorities. The items are
+// validated before touching the tree, so that a panic leaves it unchanged
+func (tree *Treap) __bulkInsert(sorted []interface{}, dup bool) int {
+
+	__checkSorted(sorted, tree.Less)
+	for _, item := range sorted {
+		tree.checkKey(item)
+	}
+
+	tree.modified()
+
+	nodes := __inorderNodes(*tree.rootPtr, false, make([]*Node, 0, tree.Size()))
+	spine := make([]*Node, 0, 64)
+	appendNode := func(p *Node) {
+		p.reset()
+		spine = __appendToSpine(spine, p)
+	}
+
+	inserted := 0
+	var last interface{} // last key added to the spine
+	i := 0
+	for _, item := range sorted {
+		for i < len(nodes) && !tree.Less(item, nodes[i].key) { // existing keys go first
+			last = nodes[i].key
+			appendNode(nodes[i])
+			i++
+		}
+		if !dup && (len(spine) > 0 && !tree.Less(last, item)) {
+			continue // item is already contained
+		}
+		last = item
+		appendNode(tree.newNode(item))
+		inserted++
+	}
+	for ; i < len(nodes); i++ {
+		appendNode(nodes[i])
+	}
+
+	*tree.rootPtr = __spineRoot(spine)
+
+	return inserted
+}
+
+// BulkInsert Insert the keys of sortedItems, which must be in ascending order respect to Less. The
+// keys already contained, as well as the repeated ones in sortedItems, are skipped. The in order
+// sequence of tree is merged with sortedItems and the tree is rebuilt as a Cartesian tree, so the
+// complexity is O(n + m) instead of the O(m log(n + m)) of inserting one by one. It pays off when m
+// is comparable to n. Return the number of keys inserted. Panic if sortedItems is not sorted
+func (tree *Treap) BulkInsert(sortedItems []interface{}) int {
+	return tree.__bulkInsert(sortedItems, false)
+}
+
+// BulkInsertDup Equivalent to BulkInsert, but all the keys of sortedItems are inserted, even if
+// they are already contained. Return len(sortedItems)
+func (tree *Treap) BulkInsertDup(sortedItems []interface{}) int {
+	return tree.__bulkInsert(sortedItems, true)
+}
+
+// Helper for topological comparison of two trees
+func __topologicalEqual(t1, t2 *Node, less func(i1, i2 interface{}) bool) bool {
+
+	if t1 == nullNodePtr && t2 == nullNodePtr {
+		return true
+	}
+
+	if (t1 == nullNodePtr && t2 != nullNodePtr) || (t1 != nullNodePtr && t2 == nullNodePtr) {
+		return false
+	}
+
+	if !__equal(t1.key, t2.key, less) {
+		return false // keys are different
+	}
+
+	return __topologicalEqual(t1.llink, t2.llink, less) &&
+		__topologicalEqual(t1.rlink, t2.rlink, less)
+}
+
+// Return true if tree is topologically equivalent to rhs
+func (tree *Treap) TopologicalEqual(rhs *Treap) bool {
+	return __topologicalEqual(*tree.rootPtr, *rhs.rootPtr, tree.Less)
+}
+
+// Helper that hangs p at the end of path and restores the heap order by rotating on the way
+// back up. path contains the nodes visited from the root and toLeft[i] tells whether the descent
+// continued through the left child of path[i]. Return the new root
+func __insertPath(path []*Node, toLeft []bool, p *Node) *Node {
+
+	resultNode := p
+	for i := len(path) - 1; i >= 0; i-- {
+		root := path[i]
+		root.count++
+		if toLeft[i] {
+			root.llink = resultNode
+			if resultNode.priority < root.priority {
+				root = rotateRight(root)
+			}
+		} else {
+			root.rlink = resultNode
+			if resultNode.priority < root.priority {
+				root = rotateLeft(root)
+			}
+		}
+		resultNode = root
+	}
+
+	return resultNode
+}
+
+// Helper for inserting node p into the tree root. BST order is handled through cmp function.
+// The insertion is iterative, so the stack does not grow with the height of the tree
+func __insertNode(root, p *Node, cmp func(i1, i2 interface{}) int) *Node {
+
+	path := make([]*Node, 0, 64)
+	toLeft := make([]bool, 0, 64)
+	for root != nullNodePtr {
+		root = __own(root)
+		path = append(path, root)
+		c := cmp(p.key, root.key)
+		if c < 0 {
+			toLeft = append(toLeft, true)
+			root = root.llink
+		} else if c > 0 {
+			toLeft = append(toLeft, false)
+			root = root.rlink
+		} else {
+			return nullNodePtr // key is already in tree ==> insertion fails
+		}
+	}
+
+	return __insertPath(path, toLeft, p)
+}
+
+// Insert item into the tree. Return nil if key is already contained; otherwise
+// returns the value of the just inserted item
+func (tree *Treap) Insert(item interface{}) interface{} {
+
+	p := tree.newNode(item)
+
+	result := __insertNode(*tree.rootPtr, p, tree.cmp)
+	if result == nullNodePtr {
+		tree.releaseNode(p)
+		return nil
+	}
+
+	*tree.rootPtr = result
+	tree.modified()
+	tree.notify(tree.onInsert, p.key)
+	return p.key
+}
+
+// InsertMany Insert every item into the tree. Items already contained are skipped. Return the
+// number of items actually inserted
+func (tree *Treap) InsertMany(items ...interface{}) int {
+
+	n := 0
+	for _, item := range items {
+		if tree.Insert(item) != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Append equivalent to insert. Put for supporting functional operations
+func (tree *Treap) Append(item interface{}, items ...interface{}) interface{} {
+	tree.Insert(item)
+	for _, i := range items {
+		tree.Insert(i)
+	}
+	return tree
+}
+
+// Helper for inserting node p into the tree root. BST order is handled through less function.
+// key stored in p can be already present in the tree,. In this case, The key will be duplicated
+func __insertNodeDup(root, p *Node, cmp func(i1, i2 interface{}) int) *Node {
+
+	path := make([]*Node, 0, 64)
+	toLeft := make([]bool, 0, 64)
+	for root != nullNodePtr {
+		root = __own(root)
+		path = append(path, root)
+		if cmp(p.key, root.key) < 0 {
+			toLeft = append(toLeft, true)
+			root = root.llink
+		} else {
+			toLeft = append(toLeft, false)
+			root = root.rlink
+		}
+	}
+
+	return __insertPath(path, toLeft, p)
+}
+
+// Insert item into the tree. Return nil if key is already contained; otherwise
+// returns the value of the just inserted item
+func (tree *Treap) InsertDup(item interface{}) interface{} {
+
+	tree.modified()
+	p := tree.newNode(item)
+
+	result := __insertNodeDup(*tree.rootPtr, p, tree.cmp)
+
+	*tree.rootPtr = result
+	tree.notify(tree.onInsert, p.key)
+	return p.key
+}
+
+// Helper for searching key in tree root. Return the node containing key or nullNodePtr if key
+// is not found
+func __search(root *Node, key interface{}, cmp func(i1, i2 interface{}) int) *Node {
+
+	for root != nullNodePtr {
+
+		c := cmp(key, root.key)
+		if c < 0 {
+			root = root.llink
+		} else if c > 0 {
+			root = root.rlink
+		} else {
+			break // key found!
+		}
+	}
+
+	return root
+}
+
+// Helper for searching key in the tree pointed by rootPtr. The nodes on the search path are made
+// private, so that the found node can be modified. Return the node containing key or nullNodePtr
+func __searchOwned(rootPtr **Node, key interface{}, cmp func(i1, i2 interface{}) int) *Node {
+
+	for *rootPtr != nullNodePtr {
+
+		*rootPtr = __own(*rootPtr)
+		c := cmp(key, (*rootPtr).key)
+		if c < 0 {
+			rootPtr = &(*rootPtr).llink
+		} else if c > 0 {
+			rootPtr = &(*rootPtr).rlink
+		} else {
+			break // key found!
+		}
+	}
+
+	return *rootPtr
+}
+
+// Search in tree key. If key is found, then the value contained in the set is returned.
+// Otherwise, the key was not found, nil value is returned
+func (tree *Treap) Search(key interface{}) interface{} {
+
+	root := __search(*tree.rootPtr, key, tree.cmp)
+	if root == nullNodePtr {
+		return nil
+	}
+
+	return root.key
+}
+
+// Return true if key is found in tree
+func (tree *Treap) Has(key interface{}) bool {
+	return tree.Search(key) != nil
+}
+
+// Helper function for searching a node and eventually Insert it into the tree if it is not found.
+// The key of p is compared once against every node of the search path
+func __searchOrInsertNode(root **Node, p *Node, cmp func(i1, i2 interface{}) int) *Node {
+
+	if *root == nullNodePtr {
+		*root = p
+		return p
+	}
+
+	*root = __own(*root)
+	c := cmp(p.key, (*root).key)
+	if c < 0 {
+		ret := __searchOrInsertNode(&(*root).llink, p, cmp)
+		if ret == p {
+			(*root).count++
+			if ret.priority < (*root).priority {
+				*root = rotateRight(*root)
+			}
+		}
+		return ret
+	}
+
+	if c > 0 {
+		ret := __searchOrInsertNode(&(*root).rlink, p, cmp)
+		if ret == p {
+			(*root).count++
+			if ret.priority < (*root).priority {
+				*root = rotateLeft(*root)
+			}
+		}
+		return ret
+	}
+
+	return *root // key is already in tree ==> insertion fails
+}
+
+// Helper function for searching a node and eventually Insert it into the tree if it is not found,
+// which spends a single call to less per node of the search path. last is the last node where
+// the search went to the right, the only one that could be equal to the key of p. So equality
+// is checked once, when the search reaches an empty subtree
+func __searchOrInsertNodeLess(root **Node, p *Node, less func(i1, i2 interface{}) bool,
+	last *Node) *Node {
+
+	if *root == nullNodePtr {
+		if last != nullNodePtr && !less(last.key, p.key) {
+			return last // key is already in tree ==> insertion fails
+		}
+		*root = p
+		return p
+	}
+
+	*root = __own(*root)
+	if less(p.key, (*root).key) {
+		ret := __searchOrInsertNodeLess(&(*root).llink, p, less, last)
+		if ret == p {
+			(*root).count++
+			if ret.priority < (*root).priority {
+				*root = rotateRight(*root)
+			}
+		}
+		return ret
+	}
+
+	ret := __searchOrInsertNodeLess(&(*root).rlink, p, less, *root)
+	if ret == p {
+		(*root).count++
+		if ret.priority < (*root).priority {
+			*root = rotateLeft(*root)
+		}
+	}
+	return ret
+}
+
+// Search the key of p and insert p if it is not found. Return the node holding the key. Trees
+// built with NewWithCmp stop as soon as the key is found; otherwise, a single call to Less is
+// spent per node
+func (tree *Treap) searchOrInsertNode(p *Node) *Node {
+	if tree.threeWay {
+		return __searchOrInsertNode(tree.rootPtr, p, tree.cmp)
+	}
+	return __searchOrInsertNodeLess(tree.rootPtr, p, tree.Less, nullNodePtr)
+}
+
+// Search in tree item. If it is found, then the pair (false, item-value) is returned.
+// Otherwise, the item is inserted into the tree and the pair (true, item) is returned
+func (tree *Treap) SearchOrInsert(item interface{}) (bool, interface{}) {
+
+	p := tree.newNode(item)
+
+	result := tree.searchOrInsertNode(p)
+	if result != p {
+		tree.releaseNode(p)
+		return false, result.key
+	}
+
+	tree.modified()
+	return true, p.key
+}
+
+// SearchOrInsertWith Search probe in tree. If it is found, then the pair (false, stored key) is
+// returned. Otherwise, the key returned by create is inserted and the pair (true, new key) is
+// returned. create is only called when probe is not found and it must return a key equal to
+// probe respect to Less. Panic if this contract is not met
+func (tree *Treap) SearchOrInsertWith(probe interface{},
+	create func() interface{}) (bool, interface{}) {
+
+	if p := __search(*tree.rootPtr, probe, tree.cmp); p != nullNodePtr {
+		return false, p.key
+	}
+
+	key := create()
+	if !__equal(key, probe, tree.Less) {
+		panic(fmt.Sprintf("Created key %v is not equal to the probe %v", key, probe))
+	}
+
+	return tree.SearchOrInsert(key)
+}
+
+// Upsert Search item in a single descent. If it is not found, then item is inserted. Otherwise,
+// the stored key is replaced by update(stored key). Return the key stored in the tree at the
+// end of operation. Since the node is not moved, update must return a key equal to the stored one
+// respect to Less. Panic if this contract is not met
+func (tree *Treap) Upsert(item interface{}, update func(existing interface{}) interface{}) interface{} {
+
+	p := tree.newNode(item)
+
+	result := tree.searchOrInsertNode(p)
+	if result == p {
+		tree.modified()
+		return p.key
+	}
+	tree.releaseNode(p)
+
+	key := update(result.key)
+	if !__equal(key, result.key, tree.Less) {
+		panic(fmt.Sprintf("Updated key %v is not equal to the stored key %v", key, result.key))
+	}
+	result.key = key
+
+	return key
+}
+
+// ReplaceEqual Replace the stored key equal to item by item. The node keeps its place and its
+// priority, so the topology of the tree does not change. If the node is shared with a snapshot,
+// then only the path to it is copied and the snapshot keeps the old key. Return false if there is
+// not any key equal to item
+func (tree *Treap) ReplaceEqual(item interface{}) bool {
+
+	if __search(*tree.rootPtr, item, tree.cmp) == nullNodePtr {
+		return false
+	}
+
+	__searchOwned(tree.rootPtr, item, tree.cmp).key = item
+
+	return true
+}
+
+// Helper for removing key from a tree. Returns the removed node if this one is found.
+// Otherwise, nullNodePte is returned.
+func __remove(rootPtr **Node, key interface{}, cmp func(i1, i2 interface{}) int) *Node {
+
+	if *rootPtr == nullNodePtr {
+		return nullNodePtr
+	}
+
+	*rootPtr = __own(*rootPtr)
+	var retVal *Node
+	c := cmp(key, (*rootPtr).key)
+	if c < 0 {
+		retVal = __remove(&(*rootPtr).llink, key, cmp)
+	} else if c > 0 {
+		retVal = __remove(&(*rootPtr).rlink, key, cmp)
+	} else { // key found
+		retVal = *rootPtr // this node will be deleted
+		*rootPtr = __joinExclusive(&(*rootPtr).llink, &(*rootPtr).rlink)
+		retVal.reset()
+		return retVal
+	}
+
+	if retVal == nullNodePtr {
+		return nullNodePtr // key not found
+	}
+
+	(*rootPtr).count--
+
+	return retVal
+}
+
+// Remove key from the tree. Return the removed value if the removal was successful.
+// Otherwise, the item was not found and the value nil is returned as signal of the failure
+func (tree *Treap) Remove(key interface{}) interface{} {
+
+	retVal := __remove(tree.rootPtr, key, tree.cmp)
+	if retVal == nullNodePtr {
+		return nil // key not found
+	}
+
+	tree.modified()
+	removed := tree.releaseNode(retVal)
+	tree.notify(tree.onRemove, removed)
+	return removed
+}
+
+// OnInsert Register cb for being called with the inserted key after every successful Insert or
+// InsertDup. A failed Insert, because the key was already contained, does not call it. The
+// observers are called synchronously in registration order, so they must be fast, and they must
+// not modify the tree
+func (tree *Treap) OnInsert(cb func(key interface{})) {
+	tree.onInsert = append(tree.onInsert, cb)
+}
+
+// OnRemove Register cb for being called with the removed key after every successful Remove. A
+// Remove of an absent key does not call it. The same rules than OnInsert apply
+func (tree *Treap) OnRemove(cb func(key interface{})) {
+	tree.onRemove = append(tree.onRemove, cb)
+}
+
+// Call every observer with key
+func (tree *Treap) notify(observers []func(key interface{}), key interface{}) {
+	for _, cb := range observers {
+		cb(key)
+	}
+}
+
+// UpdateKey Replace oldKey by newKey, which is placed according to its order. Return false without
+// modifying the tree if oldKey is not contained or if newKey is already contained. This is the
+// right way of changing the fields of a key that determine its order
+func (tree *Treap) UpdateKey(oldKey, newKey interface{}) bool {
+
+	if !tree.Has(oldKey) {
+		return false
+	}
+
+	if __equal(oldKey, newKey, tree.Less) { // the position does not change
+		__searchOwned(tree.rootPtr, oldKey, tree.cmp).key = newKey
+		return true
+	}
+
+	if tree.Has(newKey) {
+		return false
+	}
+
+	tree.Remove(oldKey)
+	tree.Insert(newKey)
+
+	return true
+}
+
+// RemoveMany Remove every item from the tree. Return the number of items actually found and
+// removed
+func (tree *Treap) RemoveMany(items ...interface{}) int {
+
+	n := 0
+	for _, item := range items {
+		if tree.Remove(item) != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+func __removePos(rootPtr **Node, i int) *Node {
+
+	*rootPtr = __own(*rootPtr)
+	root := *rootPtr
+	__pushReverse(root)
+	var retVal *Node
+	if i == root.llink.count {
 		retVal = root
 		*rootPtr = __joinExclusive(&(*rootPtr).llink, &(*rootPtr).rlink)
 		retVal.reset()
@@ -425,294 +1226,1228 @@ func __removePos(rootPtr **Node, i int) *Node {
 		retVal = __removePos(&(*rootPtr).rlink, i-(root.llink.count+1))
 	}
 
-	root.count--
+	root.count--
+
+	return retVal
+}
+
+func (tree *Treap) RemoveByPos(i int) interface{} {
+
+	if i >= tree.Size() {
+		panic(fmt.Sprintf("Invalid position %d", i))
+	}
+
+	tree.modified()
+	retVal := __removePos(tree.rootPtr, i)
+	return tree.releaseNode(retVal)
+}
+
+// RemoveByPosE Equivalent to RemoveByPos, but it returns *ErrPositionOutOfRange instead of
+// panicking if i is out of range
+func (tree *Treap) RemoveByPosE(i int) (interface{}, error) {
+
+	if i < 0 || i >= tree.Size() {
+		return nil, &ErrPositionOutOfRange{Pos: i, Size: tree.Size()}
+	}
+
+	tree.modified()
+	return tree.releaseNode(__removePos(tree.rootPtr, i)), nil
+}
+
+// PopFront Remove and return the key at position 0, or nil if the tree is empty. It is
+// equivalent to RemoveByPos(0) without the bounds check and it is done in a single descent
+func (tree *Treap) PopFront() interface{} {
+	return tree.ExtractMin()
+}
+
+// PopBack Remove and return the key at position Size() - 1, or nil if the tree is empty
+func (tree *Treap) PopBack() interface{} {
+	return tree.ExtractMax()
+}
+
+// Return the smallest item contained in the tree, or nil if the tree is empty. The leftmost node is
+// reached by walking down from the root, so the cost is O(log n) expected time (about ln n nodes
+// are visited); it is not cached. In a loop where the extremes are read far more often than the
+// tree changes, keep the returned key instead of calling Min again
+func (tree *Treap) Min() interface{} {
+
+	root := *tree.rootPtr
+	if root == nullNodePtr {
+		return nil
+	}
+
+	for root.llink != nullNodePtr {
+		root = root.llink
+	}
+
+	return root.key
+}
+
+// Return the greatest item contained in the tree, or nil if the tree is empty. As Min, it spends
+// O(log n) expected time walking down to the rightmost node
+func (tree *Treap) Max() interface{} {
+
+	root := *tree.rootPtr
+	if root == nullNodePtr {
+		return nil
+	}
+
+	for root.rlink != nullNodePtr {
+		root = root.rlink
+	}
+
+	return root.key
+}
+
+// Helper that unlinks the smallest node of a non empty tree pointed by rootPtr in a single
+// descent. The counters along the path are decremented. Return the removed node
+func __removeMin(rootPtr **Node) *Node {
+
+	*rootPtr = __own(*rootPtr)
+	__pushReverse(*rootPtr)
+	for (*rootPtr).llink != nullNodePtr {
+		(*rootPtr).count--
+		rootPtr = &(*rootPtr).llink
+		*rootPtr = __own(*rootPtr)
+		__pushReverse(*rootPtr)
+	}
+
+	retVal := *rootPtr
+	*rootPtr = retVal.rlink
+	retVal.reset()
+	return retVal
+}
+
+// Helper that unlinks the greatest node of a non empty tree pointed by rootPtr in a single
+// descent. The counters along the path are decremented. Return the removed node
+func __removeMax(rootPtr **Node) *Node {
+
+	*rootPtr = __own(*rootPtr)
+	__pushReverse(*rootPtr)
+	for (*rootPtr).rlink != nullNodePtr {
+		(*rootPtr).count--
+		rootPtr = &(*rootPtr).rlink
+		*rootPtr = __own(*rootPtr)
+		__pushReverse(*rootPtr)
+	}
+
+	retVal := *rootPtr
+	*rootPtr = retVal.llink
+	retVal.reset()
+	return retVal
+}
+
+// ExtractMin Remove and return the smallest item contained in the tree. Return nil if the tree
+// is empty
+func (tree *Treap) ExtractMin() interface{} {
+
+	tree.modified()
+	if *tree.rootPtr == nullNodePtr {
+		return nil
+	}
+
+	return tree.releaseNode(__removeMin(tree.rootPtr))
+}
+
+// ExtractMax Remove and return the greatest item contained in the tree. Return nil if the tree
+// is empty
+func (tree *Treap) ExtractMax() interface{} {
+
+	tree.modified()
+	if *tree.rootPtr == nullNodePtr {
+		return nil
+	}
+
+	return tree.releaseNode(__removeMax(tree.rootPtr))
+}
+
+// Return in O(1) the number of keys contained in the tree
+func (tree *Treap) Size() int { return (*tree.rootPtr).count }
+
+// Helper function for splitting a tree according to key. The function returns two new trees.
+// tsRoot contains all the keys less or equal than key and tgRoot contains the keys greater to
+// key. The original tree in root remains in inconsistent state and it should not be used.
+func __splitByKeyDup(root *Node, key interface{},
+	less func(i1, i2 interface{}) bool) (tsRoot, tgRoot *Node) {
+
+	if root == nullNodePtr {
+		return nullNodePtr, nullNodePtr
+	}
+
+	root = __own(root)
+	if less(key, root.key) {
+		tgRootAux := nullNodePtr
+		tgRoot = root
+		tsRoot, tgRootAux = __splitByKeyDup(root.llink, key, less)
+		tgRoot.llink = tgRootAux
+		tgRoot.count -= tsRoot.count
+	} else {
+		tsRootAux := nullNodePtr
+		tsRoot = root
+		tsRootAux, tgRoot = __splitByKeyDup(root.rlink, key, less)
+		tsRoot.rlink = tsRootAux
+		tsRoot.count -= tgRoot.count
+	}
+	return tsRoot, tgRoot
+}
+
+// Helper function for splitting a tree according to key. tsRoot contains all the keys strictly
+// less than key and tgRoot contains the keys greater or equal than key. The original tree in root
+// remains in inconsistent state and it should not be used.
+func __splitByKeyStrict(root *Node, key interface{},
+	less func(i1, i2 interface{}) bool) (tsRoot, tgRoot *Node) {
+
+	if root == nullNodePtr {
+		return nullNodePtr, nullNodePtr
+	}
+
+	root = __own(root)
+	if less(root.key, key) {
+		tsRootAux := nullNodePtr
+		tsRoot = root
+		tsRootAux, tgRoot = __splitByKeyStrict(root.rlink, key, less)
+		tsRoot.rlink = tsRootAux
+		tsRoot.count -= tgRoot.count
+	} else {
+		tgRootAux := nullNodePtr
+		tgRoot = root
+		tsRoot, tgRootAux = __splitByKeyStrict(root.llink, key, less)
+		tgRoot.llink = tgRootAux
+		tgRoot.count -= tsRoot.count
+	}
+	return tsRoot, tgRoot
+}
+
+// SplitByKey tree in two trees tsTree and tgTres. tsTree contains all the keys of tree in
+// [tree.Min(), key] and tgTree contains those ones in (key, tree.Max]. After completion,
+// tree becomes empty.
+func (tree *Treap) SplitByKey(key interface{}) (tsTree, tgTree *Treap) {
+
+	tree.modified()
+	tsTree = tree.newLike(tree.seed)
+	tgTree = tree.newLike(tree.seed)
+
+	*tsTree.rootPtr, *tgTree.rootPtr = __splitByKeyDup(*tree.rootPtr, key, tree.Less)
+
+	*tree.rootPtr = nullNodePtr
+
+	return
+}
+
+// SplitThreeWay Split tree in three trees: less contains the keys strictly less than key, equal
+// contains all the keys equal to key and greater contains the keys strictly greater than key.
+// After completion, tree becomes empty.
+func (tree *Treap) SplitThreeWay(key interface{}) (less, equal, greater *Treap) {
+
+	tree.modified()
+	less = tree.newLike(tree.seed)
+	equal = tree.newLike(tree.seed)
+	greater = tree.newLike(tree.seed)
+
+	lessOrEqual := nullNodePtr
+	lessOrEqual, *greater.rootPtr = __splitByKeyDup(*tree.rootPtr, key, tree.Less)
+	*less.rootPtr, *equal.rootPtr = __splitByKeyStrict(lessOrEqual, key, tree.Less)
+
+	*tree.rootPtr = nullNodePtr
+
+	return
+}
+
+// RemoveRangeByKey Remove from tree all the keys in the closed interval [lo, hi] and return the
+// number of removed keys. If lo > hi, then nothing is removed. The removal spends O(log n)
+// expected time, unless the tree is pooled or has an allocator, in which case the removed nodes
+// are released one by one as Clear does
+func (tree *Treap) RemoveRangeByKey(lo, hi interface{}) int {
+
+	if tree.Less(hi, lo) || tree.IsEmpty() {
+		return 0
+	}
+
+	ts, tg := tree.SplitByKey(hi) // ts = [Min, hi] tg = (hi, Max]
+	n := ts.CountLess(lo)
+	removed := ts.Size() - n
+	discarded := ts
+	if n > 0 {
+		ts, discarded = ts.SplitByPosition(n - 1) // ts = [Min, lo) discarded = [lo, hi]
+		ts.JoinExclusive(tg)
+		tg = ts
+	}
+
+	*tree.rootPtr = *tg.rootPtr
+	if tree.pooled || tree.free != nil {
+		tree.__releaseTree(*discarded.rootPtr)
+	}
+
+	return removed
+}
+
+// ExtractRangeByKey Remove from tree all the keys in the closed interval [lo, hi], duplicates
+// included, and return them as a new tree. The bounds do not need to be in the set. tree is split
+// at lo and at hi and its outer parts are joined back, so the extraction spends O(log n) expected
+// time. If lo > hi, then an empty tree is returned and tree is not modified
+func (tree *Treap) ExtractRangeByKey(lo, hi interface{}) *Treap {
+
+	ret := tree.newLike(tree.seed)
+	if tree.Less(hi, lo) || tree.IsEmpty() {
+		return ret
+	}
+
+	tree.modified()
+	l, m := __splitByKeyStrict(*tree.rootPtr, lo, tree.Less) // l = [Min, lo) m = [lo, Max]
+	m, r := __splitByKeyDup(m, hi, tree.Less)                // m = [lo, hi] r = (hi, Max]
+	*ret.rootPtr = m
+	*tree.rootPtr = __joinExclusive(&l, &r)
+
+	return ret
+}
+
+// RemoveAll Remove every copy of key and return the number of removed keys. The run of equal
+// keys is split out and dropped, so the removal spends O(log n) expected time
+func (tree *Treap) RemoveAll(key interface{}) int {
+	return tree.RemoveRangeByKey(key, key)
+}
+
+// Helper that joins two range-disjoint trees. By range-disjoint we mean that all the keys
+// in tsRootPtr are less than any key in tgRootPtr. The helper returns the resulting join
+// and the originals trees are emptied
+func __joinExclusive(tsRootPtr, tgRootPtr **Node) *Node {
+
+	if *tsRootPtr == nullNodePtr {
+		return *tgRootPtr
+	}
+
+	if *tgRootPtr == nullNodePtr {
+		return *tsRootPtr
+	}
+
+	if (*tsRootPtr).priority < (*tgRootPtr).priority {
+		*tsRootPtr = __own(*tsRootPtr)
+		__pushReverse(*tsRootPtr)
+		(*tsRootPtr).count += (*tgRootPtr).count
+		(*tsRootPtr).rlink = __joinExclusive(&(*tsRootPtr).rlink, tgRootPtr)
+		return *tsRootPtr
+	}
+
+	*tgRootPtr = __own(*tgRootPtr)
+	__pushReverse(*tgRootPtr)
+	(*tgRootPtr).count += (*tsRootPtr).count
+	(*tgRootPtr).llink = __joinExclusive(tsRootPtr, &(*tgRootPtr).llink)
+	return *tgRootPtr
+}
+
+// join exclusive of tsTree with tgTree. Equivalent to append tgTree to tsTree.
+// tgTree must be greater than tsTree. Panic is thrown if this condition is not met
+func (tsTree *Treap) JoinExclusive(tgTree *Treap) {
+
+	if tsTree.JoinExclusiveE(tgTree) != nil {
+		panic("Trees are not range-disjoint")
+	}
+}
+
+// JoinExclusiveE Equivalent to JoinExclusive, but it returns *ErrNotRangeDisjoint instead of
+// panicking if tsTree.Max() is not less than tgTree.Min(). In that case both trees are unmodified
+func (tsTree *Treap) JoinExclusiveE(tgTree *Treap) error {
+
+	if tsTree.Size() != 0 && tgTree.Size() != 0 && !tsTree.Less(tsTree.Max(), tgTree.Min()) {
+		return &ErrNotRangeDisjoint{Max: tsTree.Max(), Min: tgTree.Min()}
+	}
+
+	tsTree.modified()
+	tgTree.modified()
+	*tsTree.rootPtr = __joinExclusive(tsTree.rootPtr, tgTree.rootPtr)
+	*tgTree.rootPtr = nullNodePtr
+
+	return nil
+}
+
+// Helper that merges the sorted nodes of ts and tg into a Cartesian tree keeping their priorities.
+// On ties the nodes of ts go first and the nodes of tg whose key equals the last added key are
+// discarded through release
+func __mergeNodes(ts, tg []*Node, less func(i1, i2 interface{}) bool, release func(p *Node)) *Node {
+
+	spine := make([]*Node, 0, 64)
+	i, j := 0, 0
+	for i < len(ts) || j < len(tg) {
+		if j == len(tg) || (i < len(ts) && !less(tg[j].key, ts[i].key)) {
+			ts[i].reset()
+			spine = __appendToSpine(spine, ts[i])
+			i++
+			continue
+		}
+		p := tg[j]
+		j++
+		if len(spine) > 0 && !less(spine[len(spine)-1].key, p.key) {
+			release(p) // key already added
+			continue
+		}
+		p.reset()
+		spine = __appendToSpine(spine, p)
+	}
+
+	return __spineRoot(spine)
+}
+
+// Join Do the union of tsTree with tgTree, whose ranges may overlap. The keys of tgTree already
+// contained in tsTree are discarded. At the end of operation tgTree becomes empty.
+//
+// Both trees are split at the bounds of the intersection of their ranges. The parts outside it
+// are joined as JoinExclusive does and only the keys inside it are merged, so the complexity is
+// O(log n + log m + k), where k is the number of keys of both trees in the overlapping range. If
+// the ranges are disjoint, then it is as fast as JoinExclusive; if they overlap entirely, then it
+// is linear in n + m
+func (tsTree *Treap) Join(tgTree *Treap) {
+
+	tsTree.modified()
+	tgTree.modified()
+	if tsTree.IsEmpty() || tgTree.IsEmpty() {
+		*tsTree.rootPtr = __joinExclusive(tsTree.rootPtr, tgTree.rootPtr)
+		*tgTree.rootPtr = nullNodePtr
+		return
+	}
+
+	less := tsTree.Less
+	lo, hi := tsTree.Min(), tsTree.Max() // overlapping range [lo, hi]
+	if less(lo, tgTree.Min()) {
+		lo = tgTree.Min()
+	}
+	if less(tgTree.Max(), hi) {
+		hi = tgTree.Max()
+	}
+
+	if less(hi, lo) { // disjoint ranges
+		if less(tsTree.Max(), tgTree.Min()) {
+			*tsTree.rootPtr = __joinExclusive(tsTree.rootPtr, tgTree.rootPtr)
+		} else {
+			*tsTree.rootPtr = __joinExclusive(tgTree.rootPtr, tsTree.rootPtr)
+		}
+		*tgTree.rootPtr = nullNodePtr
+		return
+	}
+
+	// split a tree in the keys less than lo, the keys in [lo, hi] and the keys greater than hi
+	split := func(root *Node) (l, m, r *Node) {
+		l, m = __splitByKeyStrict(root, lo, less)
+		m, r = __splitByKeyDup(m, hi, less)
+		return
+	}
+
+	tsL, tsM, tsR := split(*tsTree.rootPtr)
+	tgL, tgM, tgR := split(*tgTree.rootPtr)
+	*tgTree.rootPtr = nullNodePtr
+
+	m := __mergeNodes(__inorderNodes(tsM, false, make([]*Node, 0, tsM.count)),
+		__inorderNodes(tgM, false, make([]*Node, 0, tgM.count)), less,
+		func(p *Node) { tsTree.releaseNode(p) })
+
+	// at most one of the trees has keys less than lo and at most one has keys greater than hi
+	l := __joinExclusive(&tsL, &tgL)
+	r := __joinExclusive(&tsR, &tgR)
+	m = __joinExclusive(&l, &m)
+	*tsTree.rootPtr = __joinExclusive(&m, &r)
+}
+
+func __joinDup(rootPtr **Node, root *Node, cmp func(k1, k2 interface{}) int) {
+
+	if root == nullNodePtr {
+		return
+	}
+
+	root = __own(root)
+	l, r := root.llink, root.rlink
+	root.llink, root.rlink, root.count = nullNodePtr, nullNodePtr, 1
+	*rootPtr = __insertNodeDup(*rootPtr, root, cmp)
+	__joinDup(rootPtr, l, cmp)
+	__joinDup(rootPtr, r, cmp)
+}
+
+// join rhs with tree. The result is equivalent to the union of tree and rhs
+// Notice that keys could be repeated. At the end of operation rhs becomes empty
+func (tree *Treap) JoinDup(rhs *Treap) {
+
+	tree.modified()
+	rhs.modified()
+	__joinDup(tree.rootPtr, *rhs.rootPtr, tree.cmp)
+	*rhs.rootPtr = nullNodePtr
+}
+
+// Helper that merges trees by rounds of pairwise merges, so that every key takes part in
+// O(log k) merges. In each pair the smaller tree is merged into the greater one through merge,
+// which must empty its second parameter. The result is moved to a new treap and all the trees
+// become empty
+func __mergeBalanced(trees []*Treap, merge func(tree, rhs *Treap)) *Treap {
+
+	if len(trees) == 0 {
+		panic("There are no trees to merge")
+	}
+
+	work := append(make([]*Treap, 0, len(trees)), trees...)
+	for len(work) > 1 {
+		next := make([]*Treap, 0, (len(work)+1)/2)
+		for i := 0; i < len(work); i += 2 {
+			if i+1 == len(work) {
+				next = append(next, work[i])
+				break
+			}
+			t1, t2 := work[i], work[i+1]
+			if t1.Size() < t2.Size() {
+				t1, t2 = t2, t1
+			}
+			merge(t1, t2)
+			next = append(next, t1)
+		}
+		work = next
+	}
 
-	return retVal
+	ret := trees[0].newLike(trees[0].seed)
+	*ret.rootPtr = *work[0].rootPtr
+	*work[0].rootPtr = nullNodePtr
+
+	return ret
 }
 
-func (tree *Treap) RemoveByPos(i int) interface{} {
+// MergeDup Return a new treap containing all the keys of trees, duplicates included. All the
+// trees must have been built with equivalent comparison functions; the one of trees[0] is used.
+// At the end of operation all the trees become empty. Panic if trees is empty
+func MergeDup(trees ...*Treap) *Treap {
 
-	if i >= tree.Size() {
-		panic(fmt.Sprintf("Invalid position %d", i))
-	}
+	return __mergeBalanced(trees, func(tree, rhs *Treap) {
+		tree.JoinDup(rhs)
+	})
+}
 
-	retVal := __removePos(tree.rootPtr, i)
-	return retVal.key
+// MergeUnion Return a new treap containing the union of trees. A key contained in several
+// trees is kept only once. All the trees must have been built with equivalent comparison
+// functions; the one of trees[0] is used. At the end of operation all the trees become empty.
+// Panic if trees is empty
+func MergeUnion(trees ...*Treap) *Treap {
+
+	return __mergeBalanced(trees, func(tree, rhs *Treap) {
+		tree.Union(rhs)
+		rhs.Clear()
+	})
 }
 
-// Return the smallest item contained in the tree
-func (tree *Treap) Min() interface{} {
+// Union of root tree on tree. Keys of root that are not in tree are copied, with nodes taken
+// from tree, without mutating root
+func (tree *Treap) __union(root *Node) {
 
-	root := *tree.rootPtr
 	if root == nullNodePtr {
-		return nil
+		return
 	}
 
-	for root.llink != nullNodePtr {
-		root = root.llink
+	p := tree.allocNode()
+	p.key = root.key
+	p.priority = root.priority
+
+	result := __insertNode(*tree.rootPtr, p, tree.cmp)
+	if result != nullNodePtr {
+		*tree.rootPtr = result
+	} else {
+		tree.releaseNode(p)
 	}
+	tree.__union(root.llink)
+	tree.__union(root.rlink)
+}
 
-	return root.key
+// Do the union of keys of rhs with tree. At the end of operation tree contains the union of
+// both sets. Keys of rhs already contained in tree are not inserted. rhs is not modified, its keys
+// are copied into tree
+func (tree *Treap) Union(rhs *Treap) {
+
+	tree.modified()
+	tree.__union(*rhs.rootPtr)
 }
 
-// Return the greatest item contained in the tree
-func (tree *Treap) Max() interface{} {
+// UnionCopy Return a new treap containing the union of tree and rhs. Keys are not repeated in
+// the result. Neither tree nor rhs are modified. Complexity is O((n+m) log(n+m))
+func (tree *Treap) UnionCopy(rhs *Treap) *Treap {
+
+	ret := tree.newLike(tree.seed)
+	ret.__union(*tree.rootPtr)
+	ret.__union(*rhs.rootPtr)
+
+	return ret
+}
+
+// Helper for difference. root tree is traversed in preorder and a copy, with a node taken from
+// tree, of every node whose key is not contained in rhs is inserted into tree. root and rhs are
+// not modified
+func (tree *Treap) __difference(root, rhs *Node) {
 
-	root := *tree.rootPtr
 	if root == nullNodePtr {
-		return nil
+		return
 	}
 
-	for root.rlink != nullNodePtr {
-		root = root.rlink
+	if __search(rhs, root.key, tree.cmp) == nullNodePtr {
+		p := tree.allocNode()
+		p.key = root.key
+		p.priority = root.priority
+		*tree.rootPtr = __insertNodeDup(*tree.rootPtr, p, tree.cmp)
 	}
 
-	return root.key
+	tree.__difference(root.llink, rhs)
+	tree.__difference(root.rlink, rhs)
 }
 
-// Return in O(1) the number of keys contained in the tree
-func (tree *Treap) Size() int { return (*tree.rootPtr).count }
+// Difference Return a new treap containing the keys of tree that are not in rhs. Neither tree
+// nor rhs are modified. Complexity is O(n (log m + log n))
+func (tree *Treap) Difference(rhs *Treap) *Treap {
 
-// Helper function for splitting a tree according to key. The function returns two new trees.
-// tsRoot contains all the keys less or equal than key and tgRoot contains the keys greater to
-// key. The original tree in root remains in inconsistent state and it should not be used.
-func __splitByKeyDup(root *Node, key interface{},
-	less func(i1, i2 interface{}) bool) (tsRoot, tgRoot *Node) {
+	ret := tree.newLike(tree.seed)
+	ret.__difference(*tree.rootPtr, *rhs.rootPtr)
+
+	return ret
+}
+
+// SymmetricDifference Return a new treap containing the keys that are either in tree or in rhs,
+// but not in both. Neither tree nor rhs are modified
+func (tree *Treap) SymmetricDifference(rhs *Treap) *Treap {
+
+	ret := tree.newLike(tree.seed)
+	ret.__difference(*tree.rootPtr, *rhs.rootPtr)
+	ret.__difference(*rhs.rootPtr, *tree.rootPtr)
+
+	return ret
+}
+
+// Subtract Remove from tree every key contained in rhs, all its copies included, and return the
+// number of removed keys. rhs is not modified. It is the mutating counterpart of Difference. A small
+// rhs is removed key by key in O(m log n). Otherwise, both trees are walked in order as in a merge
+// and tree is rebuilt from the nodes that are kept, which keep their priorities, in O(n + m).
+// Subtracting tree from itself clears it
+func (tree *Treap) Subtract(rhs *Treap) int {
+
+	if rhs == tree {
+		removed := tree.Size()
+		tree.Clear()
+		return removed
+	}
+
+	n, m := tree.Size(), rhs.Size()
+	if n == 0 || m == 0 {
+		return 0
+	}
+
+	if m*bits.Len(uint(n)) < n {
+		removed := 0
+		for it := NewIterator(rhs); it.HasCurr(); __skipEqual(it, tree.Less) {
+			removed += tree.RemoveAll(it.GetCurr())
+		}
+		return removed
+	}
+
+	tree.modified()
+	spine := make([]*Node, 0, 64)
+	removed := 0
+	it := NewIterator(rhs)
+	for _, p := range __inorderNodes(*tree.rootPtr, false, make([]*Node, 0, n)) {
+		for it.HasCurr() && tree.Less(it.GetCurr(), p.key) {
+			it.Next()
+		}
+		if it.HasCurr() && !tree.Less(p.key, it.GetCurr()) { // p.key is in rhs
+			tree.releaseNode(p)
+			removed++
+			continue
+		}
+		p.reset()
+		spine = __appendToSpine(spine, p)
+	}
+	*tree.rootPtr = __spineRoot(spine)
+
+	return removed
+}
+
+// IsSubsetOf Return true if every key of tree is contained in other. The empty set is subset of
+// any set. The traversal stops as soon as a missing key is found. Complexity is O(n log m)
+func (tree *Treap) IsSubsetOf(other *Treap) bool {
+
+	return tree.Traverse(func(key interface{}) bool {
+		return __search(*other.rootPtr, key, tree.cmp) != nullNodePtr
+	})
+}
+
+// IsSupersetOf Return true if every key of other is contained in tree
+func (tree *Treap) IsSupersetOf(other *Treap) bool {
+	return other.IsSubsetOf(tree)
+}
+
+// helper for intersecting. root tree is traversed in preorder and its nodes inserted into
+// the intersection result or in diff1. nodes of rhs belonging to the intersection are deleted.
+func __intersectionPrefix(root *Node, rhsPtr, result, diff1, diff2 **Node,
+	cmp func(k1, k2 interface{}) int) {
 
 	if root == nullNodePtr {
-		return nullNodePtr, nullNodePtr
+		return
 	}
 
-	if less(key, root.key) {
-		tgRootAux := nullNodePtr
-		tgRoot = root
-		tsRoot, tgRootAux = __splitByKeyDup(root.llink, key, less)
-		tgRoot.llink = tgRootAux
-		tgRoot.count -= tsRoot.count
+	root = __own(root)
+	key := root.key
+	l, r := root.llink, root.rlink
+	p1 := root
+	p1.reset() // children saved in l and r
+	p2 := __remove(rhsPtr, key, cmp)
+	if p2 != nullNodePtr { // is the key in both sets?
+		q := __insertNode(*result, p1, cmp)
+		if q != nil { // p1.key could be duplicated in rootPtr. In this case we delete
+			*result = q
+		}
 	} else {
-		tsRootAux := nullNodePtr
-		tsRoot = root
-		tsRootAux, tgRoot = __splitByKeyDup(root.rlink, key, less)
-		tsRoot.rlink = tsRootAux
-		tsRoot.count -= tgRoot.count
+		*diff1 = __insertNodeDup(*diff1, p1, cmp)
 	}
-	return tsRoot, tgRoot
+
+	__intersectionPrefix(l, rhsPtr, result, diff1, diff2, cmp)
+	__intersectionPrefix(r, rhsPtr, result, diff1, diff2, cmp)
 }
 
-// SplitByKey tree in two trees tsTree and tgTres. tsTree contains all the keys of tree in
-// [tree.Min(), key) and tgTree contains those ones in [key, tree.Max]. After completion,
-// tree becomes empty.
-func (tree *Treap) SplitByKey(key interface{}) (tsTree, tgTree *Treap) {
+// Compute the intersection of tree with rhs. Intersection is put on result and remaining keys
+// are put on diff1 and diff2 respectively
+func (tree *Treap) Intersection(rhs *Treap) (result, diff1, diff2 *Treap) {
 
-	tsTree = New(tree.seed, tree.Less)
-	tgTree = New(tree.seed, tree.Less)
+	tree.modified()
+	result = tree.newLike(time.Now().UTC().UnixNano())
+	diff1 = tree.newLike(time.Now().UTC().UnixNano())
+	diff2 = tree.newLike(time.Now().UTC().UnixNano())
 
-	*tsTree.rootPtr, *tgTree.rootPtr = __splitByKeyDup(*tree.rootPtr, key, tree.Less)
+	__intersectionPrefix(*tree.rootPtr, rhs.rootPtr, result.rootPtr,
+		diff1.rootPtr, diff2.rootPtr, tree.cmp)
 
 	*tree.rootPtr = nullNodePtr
+	diff2.JoinDup(rhs)
 
 	return
 }
 
-// Helper that joins two range-disjoint trees. By range-disjoint we mean that all the keys
-// in tsRootPtr are less than any key in tgRootPtr. The helper returns the resulting join
-// and the originals trees are emptied
-func __joinExclusive(tsRootPtr, tgRootPtr **Node) *Node {
+// Helper that returns a new tree like tree containing keys, which are sorted and distinct. The tree
+// is built in linear time as a Cartesian tree
+func (tree *Treap) newFromSorted(keys []interface{}) *Treap {
+
+	ret := tree.newLike(time.Now().UTC().UnixNano())
+	spine := make([]*Node, 0, 64)
+	for _, key := range keys {
+		spine = __appendToSpine(spine, ret.newNode(key))
+	}
+	*ret.rootPtr = __spineRoot(spine)
+
+	return ret
+}
+
+// IntersectionCopy Return a new tree with the keys contained in both tree and rhs. Neither tree nor
+// rhs are modified and the keys are taken from tree. The smaller tree is walked in order and every
+// key is searched in the larger, so the complexity is O(min(n, m) log max(n, m)). Duplicated keys
+// appear once in the result
+func (tree *Treap) IntersectionCopy(rhs *Treap) *Treap {
+
+	small, large := tree, rhs
+	if rhs.Size() < tree.Size() {
+		small, large = rhs, tree
+	}
+
+	keys := make([]interface{}, 0)
+	small.Traverse(func(key interface{}) bool {
+		if len(keys) > 0 && __equal(keys[len(keys)-1], key, tree.Less) {
+			return true // duplicated key
+		}
+		if p := __search(*large.rootPtr, key, tree.cmp); p != nullNodePtr {
+			if large == tree {
+				key = p.key
+			}
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	return tree.newFromSorted(keys)
+}
+
+// IntersectionMerge Return a new tree with the keys contained in both tree and rhs, as
+// IntersectionCopy does, but both trees are walked in order simultaneously, as in a merge. So
+// the complexity is O(n + m), which is better than IntersectionCopy when both trees have similar
+// sizes. Neither tree nor rhs are modified
+func (tree *Treap) IntersectionMerge(rhs *Treap) *Treap {
+
+	keys := make([]interface{}, 0)
+	it1, it2 := NewIterator(tree), NewIterator(rhs)
+	for it1.HasCurr() && it2.HasCurr() {
+		key1, key2 := it1.GetCurr(), it2.GetCurr()
+		if tree.Less(key1, key2) {
+			it1.Next()
+		} else if tree.Less(key2, key1) {
+			it2.Next()
+		} else {
+			keys = append(keys, key1)
+			__skipEqual(it1, tree.Less)
+			__skipEqual(it2, tree.Less)
+		}
+	}
+
+	return tree.newFromSorted(keys)
+}
+
+// SetDiffs Return in new trees the keys only contained in tree, the keys contained in both trees
+// and the keys only contained in rhs. It is the non destructive counterpart of Intersection:
+// both trees are walked in order simultaneously and every key is classified, so neither tree nor
+// rhs are modified and the complexity is O(n + m). The results do not have repeated keys; the
+// keys of both are taken from tree
+func (tree *Treap) SetDiffs(rhs *Treap) (onlyLeft, both, onlyRight *Treap) {
+
+	left, common, right := make([]interface{}, 0), make([]interface{}, 0), make([]interface{}, 0)
+	it1, it2 := NewIterator(tree), NewIterator(rhs)
+	for it1.HasCurr() || it2.HasCurr() {
+		if !it2.HasCurr() || (it1.HasCurr() && tree.Less(it1.GetCurr(), it2.GetCurr())) {
+			left = append(left, it1.GetCurr())
+			__skipEqual(it1, tree.Less)
+		} else if !it1.HasCurr() || tree.Less(it2.GetCurr(), it1.GetCurr()) {
+			right = append(right, it2.GetCurr())
+			__skipEqual(it2, tree.Less)
+		} else {
+			common = append(common, it1.GetCurr())
+			__skipEqual(it1, tree.Less)
+			__skipEqual(it2, tree.Less)
+		}
+	}
+
+	return tree.newFromSorted(left), tree.newFromSorted(common), tree.newFromSorted(right)
+}
+
+// Disjoint Return true if tree and rhs do not have any key in common. Neither tree nor rhs are
+// modified. The smaller tree is walked and its keys searched in the larger one until the first
+// common key is found, so the complexity is O(min(n, m) log max(n, m)) in the worst case
+func (tree *Treap) Disjoint(rhs *Treap) bool {
+
+	small, large := tree, rhs
+	if rhs.Size() < tree.Size() {
+		small, large = rhs, tree
+	}
+
+	return small.Traverse(func(key interface{}) bool {
+		return __search(*large.rootPtr, key, tree.cmp) == nullNodePtr
+	})
+}
+
+// Return the pos-th node
+func __choose(root *Node, pos int) *Node {
+
+	l, r, rev := __children(root, false)
+	for i := pos; i != l.count; {
+		if i < l.count {
+			root = l
+		} else {
+			i -= l.count + 1
+			root = r
+		}
+		l, r, rev = __children(root, rev)
+	}
+	return root
+}
+
+// Return the key located in the position pos respect to the order of the keys.
+// The item is retrieved in O(log n) expected time.
+// Panic if pos is greater or equal to the number of elements stored into the tree
+func (tree *Treap) Choose(pos int) interface{} {
+
+	root := *tree.rootPtr
+	if pos >= root.count {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+
+	return __choose(*tree.rootPtr, pos).key
+}
+
+// ChooseE Equivalent to Choose, but it returns *ErrPositionOutOfRange instead of panicking if pos
+// is out of range
+func (tree *Treap) ChooseE(pos int) (interface{}, error) {
+
+	if pos < 0 || pos >= tree.Size() {
+		return nil, &ErrPositionOutOfRange{Pos: pos, Size: tree.Size()}
+	}
+
+	return __choose(*tree.rootPtr, pos).key, nil
+}
+
+// Page Return up to limit keys in order starting at the position offset. The page is clamped to
+// the number of keys, so it is empty if offset is greater or equal than Size(). The first key is
+// located in O(log n) expected time and the following ones are visited by an iterator, so the
+// whole page costs O(log n + limit). Panic if offset or limit are negative
+func (tree *Treap) Page(offset, limit int) []interface{} {
+
+	if offset < 0 || limit < 0 {
+		panic(fmt.Sprintf("Invalid offset %d or limit %d", offset, limit))
+	}
+
+	if offset >= tree.Size() {
+		return []interface{}{}
+	}
+
+	if n := tree.Size() - offset; limit > n {
+		limit = n
+	}
+
+	ret := make([]interface{}, 0, limit)
+	for it := NewIteratorAt(tree, offset); len(ret) < limit; it.Next() {
+		ret = append(ret, it.GetCurr())
+	}
+
+	return ret
+}
+
+// MinN Return the k smallest keys in ascending order, or all the keys if k is greater than Size().
+// The complexity is O(log n + k). Panic if k is negative
+func (tree *Treap) MinN(k int) []interface{} {
+
+	if k < 0 {
+		panic(fmt.Sprintf("Invalid number of keys %d", k))
+	}
+
+	return tree.Page(0, k)
+}
+
+// MaxN Return the k largest keys in descending order, or all the keys if k is greater than Size().
+// The keys are read backward from the last one, which is reached in O(log n), and every following
+// key through its in-order predecessor in O(1) amortized, so the complexity is O(log n + k). Panic
+// if k is negative
+func (tree *Treap) MaxN(k int) []interface{} {
+
+	if k < 0 {
+		panic(fmt.Sprintf("Invalid number of keys %d", k))
+	}
+
+	if k > tree.Size() {
+		k = tree.Size()
+	}
+
+	ret := make([]interface{}, 0, k)
+	if k == 0 {
+		return ret
+	}
+
+	for it := NewReverseIterator(tree); len(ret) < k; it.Prev() {
+		ret = append(ret, it.GetCurr())
+	}
+
+	return ret
+}
+
+// Helper that computes the position of key respect to the ordered kes stored in the tree
+// root. It returns nullNodePtr if key is not contained in the tree.
+func __rank(root *Node, key interface{}, cmp func(i1, i2 interface{}) int) int {
+
+	if root == nullNodePtr {
+		return notFound
+	}
+
+	c := cmp(key, root.key)
+	if c < 0 {
+		return __rank(root.llink, key, cmp)
+	}
+
+	if c > 0 {
+		ret := __rank(root.rlink, key, cmp)
+		if ret != notFound {
+			return ret + root.llink.count + 1
+		}
+		return notFound
+	}
+
+	return root.llink.count // key found
+}
+
+// Compute the position of key respect to the order of the full set. If the key is found,
+// then the pair (true, pos) is returned, where pos is the position of key respect to the
+// order of all keys stored in the tree. Otherwise, the method returns (false, Undetermined)
+// for indicating that the key is not in the tree. See RankLowerBound for the position that an
+// absent key would take.
+// The computation spends O(log n) expected time
+func (tree *Treap) RankInOrder(key interface{}) (ok bool, pos int) {
+
+	pos = __rank(*tree.rootPtr, key, tree.cmp)
+	ok = pos != notFound
+	return
+}
+
+// KendallDistance Return the number of pairs of keys whose relative order in reference differs
+// from their order in tree. reference must contain the same keys than tree, without repetitions,
+// in any order. Every key of reference is mapped to its rank in tree and the inversions of the
+// resulting permutation are counted through an auxiliary treap of the ranks already seen, so the
+// complexity is O(n log n). Panic if reference is not a permutation of the keys of tree
+func (tree *Treap) KendallDistance(reference []interface{}) int {
+
+	if len(reference) != tree.Size() {
+		panic(fmt.Sprintf("Reference has %d keys but tree has %d", len(reference), tree.Size()))
+	}
+
+	seen := New(tree.seed, func(i1, i2 interface{}) bool { return i1.(int) < i2.(int) })
+	distance := 0
+	for _, key := range reference {
+		ok, pos := tree.RankInOrder(key)
+		if !ok {
+			panic(fmt.Sprintf("Key %v of reference is not in tree", key))
+		}
+		if seen.Insert(pos) == nil {
+			panic(fmt.Sprintf("Key %v is repeated in reference", key))
+		}
+		distance += seen.CountGreater(pos) // keys after key in tree but before it in reference
+	}
+
+	return distance
+}
+
+// RankLowerBound Return the position where key would be inserted, that is the number of keys
+// strictly less than key, whether key is contained or not. If key is contained, it is the
+// position of its first copy. It is the same value than CountLess, framed as a rank. The
+// computation spends O(log n) expected time
+func (tree *Treap) RankLowerBound(key interface{}) int {
+	return __countLess(*tree.rootPtr, key, tree.Less)
+}
+
+// RankRange Return the inclusive span [first, last] of positions occupied by the keys equal to
+// key, so last - first + 1 is the number of copies of key. ok is false if key is not contained.
+// The span is computed through a lower bound and an upper bound descents in O(log n) expected time
+func (tree *Treap) RankRange(key interface{}) (first, last int, ok bool) {
+
+	root := *tree.rootPtr
+	first = __countLess(root, key, tree.Less)
+	last = __countLessOrEqual(root, key, tree.Less) - 1
+	if last < first {
+		return notFound, notFound, false
+	}
 
-	if *tsRootPtr == nullNodePtr {
-		return *tgRootPtr
+	return first, last, true
+}
+
+// NthAfter Return the n-th key strictly greater than key, so NthAfter(key, 1) is the successor of
+// key. key does not need to be in the set. The position of the answer is computed from the number
+// of keys less or equal than key and the key is taken through Choose, so the search spends
+// O(log n) expected time whatever n is. Return (nil, false) if there are not n keys greater than
+// key. Panic if n < 1
+func (tree *Treap) NthAfter(key interface{}, n int) (interface{}, bool) {
+
+	if n < 1 {
+		panic(fmt.Sprintf("Invalid n %d", n))
 	}
 
-	if *tgRootPtr == nullNodePtr {
-		return *tsRootPtr
+	c := __countLessOrEqual(*tree.rootPtr, key, tree.Less)
+	if n > tree.Size()-c {
+		return nil, false
 	}
 
-	if (*tsRootPtr).priority < (*tgRootPtr).priority {
-		(*tsRootPtr).count += (*tgRootPtr).count
-		(*tsRootPtr).rlink = __joinExclusive(&(*tsRootPtr).rlink, tgRootPtr)
-		return *tsRootPtr
+	return __choose(*tree.rootPtr, c+n-1).key, true
+}
+
+// Helper that counts the keys of tree root that are strictly less than key
+func __countLess(root *Node, key interface{}, less func(i1, i2 interface{}) bool) int {
+
+	ret := 0
+	for root != nullNodePtr {
+		if less(root.key, key) {
+			ret += root.llink.count + 1
+			root = root.rlink
+		} else {
+			root = root.llink
+		}
 	}
 
-	(*tgRootPtr).count += (*tsRootPtr).count
-	(*tgRootPtr).llink = __joinExclusive(tsRootPtr, &(*tgRootPtr).llink)
-	return *tgRootPtr
+	return ret
 }
 
-// join exclusive of tsTree with tgTree. Equivalent to append tgTree to tsTree.
-// tgTree must be greater than tsTree. Panic is thrown if this condition is not met
-func (tsTree *Treap) JoinExclusive(tgTree *Treap) {
+// Helper that counts the keys of tree root that are less or equal than key
+func __countLessOrEqual(root *Node, key interface{}, less func(i1, i2 interface{}) bool) int {
 
-	if tsTree.Size() != 0 && tgTree.Size() != 0 && !tsTree.Less(tsTree.Max(), tgTree.Min()) {
-		panic("Trees are not range-disjoint")
+	ret := 0
+	for root != nullNodePtr {
+		if less(key, root.key) {
+			root = root.llink
+		} else {
+			ret += root.llink.count + 1
+			root = root.rlink
+		}
 	}
 
-	*tsTree.rootPtr = __joinExclusive(tsTree.rootPtr, tgTree.rootPtr)
-	*tgTree.rootPtr = nullNodePtr
+	return ret
 }
 
-func __joinDup(rootPtr **Node, root *Node, less func(k1, k2 interface{}) bool) {
+// RangeCount Return the number of keys contained in the closed interval [lo, hi]. lo and hi do
+// not need to be in the set and duplicated keys are counted. If lo > hi, then 0 is returned.
+// The computation spends O(log n) expected time
+func (tree *Treap) RangeCount(lo, hi interface{}) int {
 
-	if root == nullNodePtr {
-		return
+	if tree.Less(hi, lo) {
+		return 0
 	}
 
-	l, r := root.llink, root.rlink
-	root.llink, root.rlink, root.count = nullNodePtr, nullNodePtr, 1
-	*rootPtr = __insertNodeDup(*rootPtr, root, less)
-	__joinDup(rootPtr, l, less)
-	__joinDup(rootPtr, r, less)
+	root := *tree.rootPtr
+	return __countLessOrEqual(root, hi, tree.Less) - __countLess(root, lo, tree.Less)
 }
 
-// join rhs with tree. The result is equivalent to the union of tree and rhs
-// Notice that keys could be repeated. At the end of operation rhs becomes empty
-func (tree *Treap) JoinDup(rhs *Treap) {
+// AnyInRange Return true if at least one key of tree is in [lo, hi]. The smallest key greater or
+// equal than lo is found and compared against hi, so the check spends O(log n) expected time
+func (tree *Treap) AnyInRange(lo, hi interface{}) bool {
 
-	__joinDup(tree.rootPtr, *rhs.rootPtr, tree.Less)
-	*rhs.rootPtr = nullNodePtr
+	if tree.Less(hi, lo) {
+		return false
+	}
+
+	p := __ceiling(*tree.rootPtr, lo, tree.Less)
+	return p != nullNodePtr && !tree.Less(hi, p.key)
 }
 
-// Union of root tree on tree pointer by rootPtr. Keys of root that are not in rootPtr are
-// copied without mutating root
-func __union(rootPtr **Node, root *Node, less func(k1, k2 interface{}) bool) {
+// Helper that appends to keys, in ascending order, the keys of tree root contained in [lo, hi].
+// Subtrees out of the interval are not visited
+func __rangeSlice(root *Node, lo, hi interface{}, less func(i1, i2 interface{}) bool,
+	keys []interface{}) []interface{} {
 
 	if root == nullNodePtr {
-		return
+		return keys
 	}
 
-	p := &Node{
-		key:      root.key,
-		priority: root.priority,
-		count:    1,
-		llink:    nullNodePtr,
-		rlink:    nullNodePtr,
+	geLo := !less(root.key, lo) // root.key >= lo
+	leHi := !less(hi, root.key) // root.key <= hi
+	if geLo {
+		keys = __rangeSlice(root.llink, lo, hi, less, keys)
 	}
-
-	result := __insertNode(*rootPtr, p, less)
-	if result != nullNodePtr {
-		*rootPtr = result
+	if geLo && leHi {
+		keys = append(keys, root.key)
+	}
+	if leHi {
+		keys = __rangeSlice(root.rlink, lo, hi, less, keys)
 	}
-	__union(rootPtr, root.llink, less)
-	__union(rootPtr, root.rlink, less)
-}
-
-// Do the union of keys of rhs with tree. The result is equivalent to the union of tree and rhs
-// Notice that keys should not be repeated.
-// At the end of operation the original sets become emtpy. If the keys are no repeated, then
-// diff1 and diff2 contain the exact differences
-func (tree *Treap) Union(rhs *Treap) {
 
-	__union(tree.rootPtr, *rhs.rootPtr, tree.Less)
+	return keys
 }
 
-// helper for intersecting. root tree is traversed in preorder and its nodes inserted into
-// the intersection result or in diff1. nodes of rhs belonging to the intersection are deleted.
-func __intersectionPrefix(root *Node, rhsPtr, result, diff1, diff2 **Node,
-	less func(k1, k2 interface{}) bool) {
+// RangeSlice Return in ascending order the keys contained in the closed interval [lo, hi],
+// duplicates included. tree is not modified. The computation spends O(log n + k) expected time,
+// where k is the number of returned keys
+func (tree *Treap) RangeSlice(lo, hi interface{}) []interface{} {
 
-	if root == nullNodePtr {
-		return
+	keys := make([]interface{}, 0)
+	if tree.Less(hi, lo) {
+		return keys
 	}
 
-	key := root.key
-	l, r := root.llink, root.rlink
-	p1 := root
-	p1.reset() // children saved in l and r
-	p2 := __remove(rhsPtr, key, less)
-	if p2 != nullNodePtr { // is the key in both sets?
-		q := __insertNode(*result, p1, less)
-		if q != nil { // p1.key could be duplicated in rootPtr. In this case we delete
-			*result = q
+	return __rangeSlice(*tree.rootPtr, lo, hi, tree.Less, keys)
+}
+
+// Helper that returns the node with the greatest key less or equal than key, or nullNodePtr if
+// all the keys are greater than key
+func __floor(root *Node, key interface{}, less func(i1, i2 interface{}) bool) *Node {
+
+	ret := nullNodePtr
+	for root != nullNodePtr {
+		if less(key, root.key) {
+			root = root.llink
+		} else {
+			ret = root
+			root = root.rlink
 		}
-	} else {
-		*diff1 = __insertNodeDup(*diff1, p1, less)
 	}
 
-	__intersectionPrefix(l, rhsPtr, result, diff1, diff2, less)
-	__intersectionPrefix(r, rhsPtr, result, diff1, diff2, less)
+	return ret
 }
 
-// Compute the intersection of tree with rhs. Intersection is put on result and remaining keys
-// are put on diff1 and diff2 respectively
-func (tree *Treap) Intersection(rhs *Treap) (result, diff1, diff2 *Treap) {
+// Helper that returns the node with the smallest key greater or equal than key, or nullNodePtr
+// if all the keys are less than key
+func __ceiling(root *Node, key interface{}, less func(i1, i2 interface{}) bool) *Node {
 
-	result = NewTreap(tree.Less)
-	diff1 = NewTreap(tree.Less)
-	diff2 = NewTreap(tree.Less)
+	ret := nullNodePtr
+	for root != nullNodePtr {
+		if less(root.key, key) {
+			root = root.rlink
+		} else {
+			ret = root
+			root = root.llink
+		}
+	}
 
-	__intersectionPrefix(*tree.rootPtr, rhs.rootPtr, result.rootPtr,
-		diff1.rootPtr, diff2.rootPtr, tree.Less)
+	return ret
+}
 
-	*tree.rootPtr = nullNodePtr
-	diff2.JoinDup(rhs)
+// SearchGE Return the smallest key greater or equal than key along with its inorder position.
+// Both are computed in a single descent, so the search spends O(log n) expected time. If there
+// are several copies of the found key, then the first one is returned. ok is false if all the
+// keys are less than key
+func (tree *Treap) SearchGE(key interface{}) (foundKey interface{}, rank int, ok bool) {
+
+	pos := 0 // number of keys less than key in the subtrees left behind
+	for root := *tree.rootPtr; root != nullNodePtr; {
+		if tree.Less(root.key, key) {
+			pos += root.llink.count + 1
+			root = root.rlink
+		} else {
+			foundKey, rank, ok = root.key, pos+root.llink.count, true
+			root = root.llink
+		}
+	}
 
 	return
 }
 
-// Return the pos-th node
-func __choose(root *Node, pos int) *Node {
+// SearchLE Return the greatest key less or equal than key along with its inorder position.
+// Both are computed in a single descent. If there are several copies of the found key, then the
+// last one is returned. ok is false if all the keys are greater than key
+func (tree *Treap) SearchLE(key interface{}) (foundKey interface{}, rank int, ok bool) {
 
-	for i := pos; i != root.llink.count; {
-		if i < root.llink.count {
+	pos := 0
+	for root := *tree.rootPtr; root != nullNodePtr; {
+		if tree.Less(key, root.key) {
 			root = root.llink
 		} else {
-			i -= root.llink.count + 1
+			foundKey, rank, ok = root.key, pos+root.llink.count, true
+			pos += root.llink.count + 1
 			root = root.rlink
 		}
 	}
-	return root
-}
-
-// Return the key located in the position pos respect to the order of the keys.
-// The item is retrieved in O(log n) expected time.
-// Panic if pos is greater or equal to the number of elements stored into the tree
-func (tree *Treap) Choose(pos int) interface{} {
-
-	root := *tree.rootPtr
-	if pos >= root.count {
-		panic(fmt.Sprintf("Position %d out of range", pos))
-	}
 
-	return __choose(*tree.rootPtr, pos).key
+	return
 }
 
-// Helper that computes the position of key respect to the ordered kes stored in the tree
-// root. It returns nullNodePtr if key is not contained in the tree.
-func __rank(root *Node, key interface{}, less func(i1, i2 interface{}) bool) int {
+// Nearest Return the stored key minimizing dist to key. The candidates are the greatest key less
+// or equal than key and the smallest key greater or equal than key, so dist is evaluated at most
+// twice. In a tie the smaller key is returned. Return (nil, false) if the tree is empty
+func (tree *Treap) Nearest(key interface{}, dist func(a, b interface{}) float64) (interface{}, bool) {
 
-	if root == nullNodePtr {
-		return notFound
+	floor := __floor(*tree.rootPtr, key, tree.Less)
+	ceiling := __ceiling(*tree.rootPtr, key, tree.Less)
+
+	if floor == nullNodePtr && ceiling == nullNodePtr {
+		return nil, false
 	}
 
-	if less(key, root.key) {
-		return __rank(root.llink, key, less)
+	if floor == nullNodePtr {
+		return ceiling.key, true
 	}
 
-	if less(root.key, key) {
-		ret := __rank(root.rlink, key, less)
-		if ret != notFound {
-			return ret + root.llink.count + 1
-		}
-		return notFound
+	if ceiling == nullNodePtr || dist(floor.key, key) <= dist(ceiling.key, key) {
+		return floor.key, true
 	}
 
-	return root.llink.count // key found
+	return ceiling.key, true
 }
 
-// Compute the position of key respect to the order of the full set. If the key is found,
-// then the pair (true, pos) is returned, where pos is the position of key respect to the
-// order of all keys stored in the tree. Otherwise, the method returns (false, Undetermined)
-// for indicating that the key is not in the tree.
+// CountLess Return the number of keys strictly less than key. key does not need to be in the set.
 // The computation spends O(log n) expected time
-func (tree *Treap) RankInOrder(key interface{}) (ok bool, pos int) {
+func (tree *Treap) CountLess(key interface{}) int {
+	return __countLess(*tree.rootPtr, key, tree.Less)
+}
 
-	pos = __rank(*tree.rootPtr, key, tree.Less)
-	ok = pos != notFound
-	return
+// CountGreater Return the number of keys strictly greater than key. key does not need to be in
+// the set. The computation spends O(log n) expected time
+func (tree *Treap) CountGreater(key interface{}) int {
+	return tree.Size() - __countLessOrEqual(*tree.rootPtr, key, tree.Less)
+}
+
+// CountOf Return the number of copies of key stored in the tree, or 0 if key is not contained.
+// The computation spends O(log n) expected time, independently of the number of copies
+func (tree *Treap) CountOf(key interface{}) int {
+
+	root := *tree.rootPtr
+	return __countLessOrEqual(root, key, tree.Less) - __countLess(root, key, tree.Less)
 }
 
 // Helper that SplitByKey tree root by position i. l = [0, i] r = [i + 1, N - 1]
 func __splitPos(root *Node, i int) (l, r *Node) {
 
+	root = __own(root)
+	__pushReverse(root)
 	if i == root.llink.count {
 		l = root
 		r = root.rlink
@@ -745,8 +2480,9 @@ func (tree *Treap) SplitByPosition(i int) (ts, tg *Treap) {
 		panic(fmt.Sprintf("Position %d out of range", i))
 	}
 
-	ts = New(tree.seed, tree.Less)
-	tg = New(tree.seed, tree.Less)
+	tree.modified()
+	ts = tree.newLike(tree.seed)
+	tg = tree.newLike(tree.seed)
 
 	if i == root.count-1 {
 		*ts.rootPtr = *tree.rootPtr
@@ -761,6 +2497,60 @@ func (tree *Treap) SplitByPosition(i int) (ts, tg *Treap) {
 	return
 }
 
+// SplitByPositionE Equivalent to SplitByPosition, but it returns *ErrPositionOutOfRange instead
+// of panicking if i is out of range. In this case tree is not modified
+func (tree *Treap) SplitByPositionE(i int) (ts, tg *Treap, err error) {
+
+	if i < 0 || i >= tree.Size() {
+		return nil, nil, &ErrPositionOutOfRange{Pos: i, Size: tree.Size()}
+	}
+
+	ts, tg = tree.SplitByPosition(i)
+	return
+}
+
+// Helper for KeepTop and KeepBottom. Keep the first k keys if bottom is true; otherwise the last k
+func (tree *Treap) keep(k int, bottom bool) {
+
+	if k < 0 {
+		panic(fmt.Sprintf("Invalid number of keys %d", k))
+	}
+
+	n := tree.Size()
+	if k >= n {
+		return
+	}
+
+	if k == 0 {
+		tree.Clear()
+		return
+	}
+
+	i := k - 1 // last position of the bottom part
+	if !bottom {
+		i = n - k - 1
+	}
+	ts, tg := tree.SplitByPosition(i)
+	kept, discarded := ts, tg
+	if !bottom {
+		kept, discarded = tg, ts
+	}
+
+	*tree.rootPtr = *kept.rootPtr
+	if tree.pooled || tree.free != nil {
+		tree.__releaseTree(*discarded.rootPtr)
+	}
+}
+
+// KeepTop Remove all the keys but the k largest ones. The tree is split once and the smaller
+// keys are discarded, so the operation spends O(log n) expected time, unless the tree is pooled
+// or has an allocator, in which case the discarded nodes are released one by one as Clear does.
+// Nothing is done if k >= Size(). Panic if k is negative
+func (tree *Treap) KeepTop(k int) { tree.keep(k, false) }
+
+// KeepBottom Remove all the keys but the k smallest ones. The same rules than KeepTop apply
+func (tree *Treap) KeepBottom(k int) { tree.keep(k, true) }
+
 // Extract from tree all the keys in [beginPos, endPos]. tree looses the extracted range
 func (tree *Treap) ExtractRange(beginPos, endPos int) *Treap {
 
@@ -783,7 +2573,146 @@ func (tree *Treap) ExtractRange(beginPos, endPos int) *Treap {
 	return result
 }
 
+// Helper that splits root in l with its first n nodes and r with the remaining ones
+func __splitFirst(root *Node, n int) (l, r *Node) {
+
+	if n == 0 {
+		return nullNodePtr, root
+	}
+
+	if n == root.count {
+		return root, nullNodePtr
+	}
+
+	return __splitPos(root, n-1)
+}
+
+// ExtractRanges Extract from tree the keys of every window [begin, end] of ranges, which must be
+// in ascending order and must not overlap. Return a tree per window, in the same order. The
+// positions refer to tree before the extraction and the remaining keys are joined back in tree.
+// Every window costs a couple of splits and a join, so the whole operation spends O(k log n)
+// expected time for k windows. Panic with the offending pair if a window is out of range, empty,
+// or not after the previous one
+func (tree *Treap) ExtractRanges(ranges [][2]int) []*Treap {
+
+	prevEnd := -1
+	for _, r := range ranges {
+		if r[0] <= prevEnd || r[0] > r[1] || r[1] >= tree.Size() {
+			panic(fmt.Sprintf("Invalid range [%d, %d] respect to number of keys %d and previous end %d",
+				r[0], r[1], tree.Size(), prevEnd))
+		}
+		prevEnd = r[1]
+	}
+
+	tree.modified()
+	ret := make([]*Treap, 0, len(ranges))
+	kept, rest := nullNodePtr, *tree.rootPtr
+	offset := 0 // position in tree of the first node of rest
+	for _, r := range ranges {
+		l, m := nullNodePtr, nullNodePtr
+		l, rest = __splitFirst(rest, r[0]-offset)
+		m, rest = __splitFirst(rest, r[1]-r[0]+1)
+		kept = __joinExclusive(&kept, &l)
+		offset = r[1] + 1
+
+		extracted := tree.newLike(tree.seed)
+		*extracted.rootPtr = m
+		ret = append(ret, extracted)
+	}
+
+	*tree.rootPtr = __joinExclusive(&kept, &rest)
+
+	return ret
+}
+
+// Helper that returns a copy of the nodes of root whose positions are in [b, e]. rev tells whether
+// an ancestor of root has a pending reversal. Only the nodes on the paths toward the positions b
+// and e are visited besides the copied ones. The nodes of the copy are taken from alloc
+func __copyRange(root *Node, b, e int, rev bool, alloc func() *Node) *Node {
+
+	if root == nullNodePtr || e < 0 || b >= root.count {
+		return nullNodePtr
+	}
+
+	if b <= 0 && e >= root.count-1 {
+		ret := __copy(root, alloc)
+		ret.reversed = ret.reversed != rev
+		return ret
+	}
+
+	l, r, childRev := __children(root, rev)
+	if e < l.count {
+		return __copyRange(l, b, e, childRev, alloc)
+	}
+	if b > l.count {
+		return __copyRange(r, b-l.count-1, e-l.count-1, childRev, alloc)
+	}
+
+	ret := alloc()
+	ret.key = root.key
+	ret.priority = root.priority
+	ret.llink = __copyRange(l, b, e, childRev, alloc)
+	ret.rlink = __copyRange(r, b-l.count-1, e-l.count-1, childRev, alloc)
+	ret.count = ret.llink.count + 1 + ret.rlink.count
+
+	return ret
+}
+
+// SliceRange Return a new tree with a copy of the keys whose positions are in [beginPos, endPos].
+// Unlike ExtractRange, tree is not modified. The copied nodes keep their priorities, so the copy
+// is built in O((endPos - beginPos) + log n) expected time. Panic if the range is invalid
+func (tree *Treap) SliceRange(beginPos, endPos int) *Treap {
+
+	if beginPos < 0 || beginPos > endPos || endPos >= tree.Size() {
+		panic(fmt.Sprintf("Invalid positions %d %d respect to number of keys %d",
+			beginPos, endPos, tree.Size()))
+	}
+
+	ret := tree.newLike(time.Now().UTC().UnixNano())
+	*ret.rootPtr = __copyRange(*tree.rootPtr, beginPos, endPos, false, ret.allocNode)
+
+	return ret
+}
+
+// ReverseRange Reverse the order of the keys whose positions are in [beginPos, endPos] in O(log n)
+// expected time. The range is split out, marked as reversed and joined back; the reversal is
+// pushed down lazily by the positional operations (Choose, RemoveByPos, SplitByPosition, PopFront,
+// PopBack and the iterators), which take it into account.
+//
+// After a reversal the tree is treated as a sequence, not as a set: the keys are no longer ordered
+// respect to Less, so the operations based on keys (Search, Insert, Remove, Min, Max, SplitByKey,
+// Validate, etc.) give meaningless results. Panic if the range is invalid
+func (tree *Treap) ReverseRange(beginPos, endPos int) {
+
+	tree.modified()
+	root := *tree.rootPtr
+	if beginPos < 0 || beginPos > endPos || endPos >= root.count {
+		panic(fmt.Sprintf("Invalid positions %d %d respect to number of keys %d",
+			beginPos, endPos, root.count))
+	}
+
+	l, m, r := nullNodePtr, root, nullNodePtr
+	if beginPos > 0 {
+		l, m = __splitPos(root, beginPos-1)
+	}
+	if endPos-beginPos < m.count-1 {
+		m, r = __splitPos(m, endPos-beginPos)
+	}
+
+	m = __own(m)
+	m.reversed = !m.reversed
+
+	l = __joinExclusive(&l, &m)
+	*tree.rootPtr = __joinExclusive(&l, &r)
+}
+
 func (tree *Treap) lexicographicCmp(rhs *Treap) int {
+	return tree.Compare(rhs)
+}
+
+// Compare tree with rhs according to the lexicographic order of their inorder key sequences.
+// Return -1 if tree is less than rhs, 0 if they are equal and 1 if tree is greater than rhs
+func (tree *Treap) Compare(rhs *Treap) int {
 
 	it1, it2 := NewIterator(tree), NewIterator(rhs)
 	for it1.HasCurr() && it2.HasCurr() {
@@ -809,6 +2738,83 @@ func (tree *Treap) lexicographicCmp(rhs *Treap) int {
 	return -1
 }
 
+// Equal Return true if tree and rhs contain exactly the same keys with the same multiplicities,
+// regardless their internal topology. Complexity is O(n)
+func (tree *Treap) Equal(rhs *Treap) bool {
+
+	if tree.Size() != rhs.Size() {
+		return false
+	}
+
+	it1, it2 := NewIterator(tree), NewIterator(rhs)
+	for it1.HasCurr() && it2.HasCurr() {
+		if !__equal(it1.GetCurr(), it2.GetCurr(), tree.Less) {
+			return false
+		}
+		it1.Next()
+		it2.Next()
+	}
+
+	return true
+}
+
+// EqualMultiset Return true if tree and rhs contain the same keys with the same multiplicities,
+// regardless the seeds and the topologies of both trees. So {1, 2, 2} and {1, 2} are different
+// multisets. It is the same test than Equal and the one to use with trees built with InsertDup.
+// See EqualSet for ignoring the multiplicities
+func (tree *Treap) EqualMultiset(rhs *Treap) bool {
+	return tree.Equal(rhs)
+}
+
+// Helper that scrambles the bits of h, so that the sum of related hashes (e.g. consecutive
+// integers) does not cancel or collide easily. It is the finalizer of splitmix64
+func __mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// Checksum Return a hash of the contents of tree that only depends on the multiset of keys, not on
+// the shape of the tree. The hashes given by hashKey are mixed and added modulo 2^64, so the order
+// does not matter and, unlike a XOR, the repeated keys are not cancelled. Equal trees, as well as a
+// tree and its Copy, have the same checksum. Complexity is O(n)
+func (tree *Treap) Checksum(hashKey func(key interface{}) uint64) uint64 {
+
+	var sum uint64
+	tree.Traverse(func(key interface{}) bool {
+		sum += __mix(hashKey(key))
+		return true
+	})
+
+	return sum
+}
+
+// Helper that advances it past all the keys equal to its current one
+func __skipEqual(it *Iterator, less func(i1, i2 interface{}) bool) {
+	key := it.GetCurr()
+	for it.Next(); it.HasCurr() && __equal(key, it.GetCurr(), less); it.Next() {
+	}
+}
+
+// EqualSet Return true if every key of tree is in rhs and vice versa, regardless how many times
+// every key is repeated. So {1, 2, 2} and {1, 2} are equal sets. Complexity is O(n + m)
+func (tree *Treap) EqualSet(rhs *Treap) bool {
+
+	it1, it2 := NewIterator(tree), NewIterator(rhs)
+	for it1.HasCurr() && it2.HasCurr() {
+		if !__equal(it1.GetCurr(), it2.GetCurr(), tree.Less) {
+			return false
+		}
+		__skipEqual(it1, tree.Less)
+		__skipEqual(it2, tree.Less)
+	}
+
+	return !it1.HasCurr() && !it2.HasCurr()
+}
+
 // Rotate p to the right. Left child becomes root
 func rotateRight(p *Node) *Node {
 	q := p.llink
@@ -829,21 +2835,121 @@ func rotateLeft(p *Node) *Node {
 	return q
 }
 
-// Iterator on Treap. Traversal is ordered
-type Iterator struct {
-	root *Node
-	curr *Node
-	pos  int
-	N    int
+// Iterator on Treap. Traversal is ordered. Forward advancing is done through the in-order
+// successor, so a complete traversal with Next spends O(n).
+//
+// Iterators are fail-fast: if the tree is structurally modified after the creation of the
+// iterator, that is a key is inserted or removed or the tree is split or joined, then Next, Prev,
+// GetCurr and Seek panic instead of walking a stale tree. Replacing a key by an equal one, as
+// Upsert or ReplaceEqual do, is not a structural modification
+type Iterator struct {
+	root     *Node
+	curr     *Node
+	pos      int
+	lo       int // position of the first item. Positions below it are before the first item
+	N        int
+	less     func(i1, i2 interface{}) bool // comparison function of the tree
+	stack    []iteratorFrame               // curr and its ancestors having curr in their left subtree
+	backward bool                          // true if stack holds the ancestors having curr in their right subtree
+	tree     *Treap                        // tree on which the iterator was created
+	modCount uint64                        // modification counter of tree at creation
+}
+
+// A node on the iterator stack. rev tells whether the children of node must be read mirrored
+type iteratorFrame struct {
+	node *Node
+	rev  bool
+}
+
+// Helper that pushes on stack p and its descendants through the left links. rev tells whether an
+// ancestor of p has a pending reversal
+func __pushLeftPath(stack []iteratorFrame, p *Node, rev bool) []iteratorFrame {
+	for p != nullNodePtr {
+		l, _, childRev := __children(p, rev)
+		stack = append(stack, iteratorFrame{p, childRev})
+		p, rev = l, childRev
+	}
+	return stack
+}
+
+// Helper that pushes on stack p and its descendants through the right links. It mirrors
+// __pushLeftPath
+func __pushRightPath(stack []iteratorFrame, p *Node, rev bool) []iteratorFrame {
+	for p != nullNodePtr {
+		_, r, childRev := __children(p, rev)
+		stack = append(stack, iteratorFrame{p, childRev})
+		p, rev = r, childRev
+	}
+	return stack
+}
+
+// Helper that builds the stack of ancestors of the pos-th node of tree root having it in their
+// right subtree. The top of the stack is the pos-th node. It mirrors __successorStack
+func __predecessorStack(root *Node, pos int) []iteratorFrame {
+
+	stack := make([]iteratorFrame, 0, 64)
+	rev := false
+	for i := pos; ; {
+		l, r, childRev := __children(root, rev)
+		if i < l.count {
+			root = l
+		} else if i == l.count {
+			return append(stack, iteratorFrame{root, childRev})
+		} else {
+			stack = append(stack, iteratorFrame{root, childRev})
+			i -= l.count + 1
+			root = r
+		}
+		rev = childRev
+	}
+}
+
+// Helper that builds the stack of ancestors of the pos-th node of tree root. The top of the
+// stack is the pos-th node
+func __successorStack(root *Node, pos int) []iteratorFrame {
+
+	stack := make([]iteratorFrame, 0, 64)
+	rev := false
+	for i := pos; ; {
+		l, r, childRev := __children(root, rev)
+		if i < l.count {
+			stack = append(stack, iteratorFrame{root, childRev})
+			root = l
+		} else if i == l.count {
+			return append(stack, iteratorFrame{root, childRev})
+		} else {
+			i -= l.count + 1
+			root = r
+		}
+		rev = childRev
+	}
 }
 
 // Initialize a treap iterator
 func initialize(it *Iterator) {
+	if it.lo > 0 {
+		it.setPos(it.lo)
+		return
+	}
 	if it.N <= 0 {
 		return
 	}
-	it.curr = __choose(it.root, 0)
 	it.pos = 0
+	it.stack = __pushLeftPath(make([]iteratorFrame, 0, 64), it.root, false)
+	it.backward = false
+	it.curr = it.stack[len(it.stack)-1].node
+}
+
+// Helper that positions the iterator on the pos-th item. The stack is discarded and it will be
+// rebuilt by Next or Prev if needed
+func (it *Iterator) setPos(pos int) {
+	it.pos = pos
+	it.stack = nil
+	if pos < it.lo || pos >= it.N {
+		it.curr = nullNodePtr
+		return
+	}
+	it.curr = __choose(it.root, pos)
 }
 
 func (tree *Treap) CreateIterator() interface{} {
@@ -854,10 +2960,13 @@ func (tree *Treap) CreateIterator() interface{} {
 // Return a iterator on the treap tree
 func NewIterator(tree *Treap) *Iterator {
 	it := &Iterator{
-		root: *tree.rootPtr,
-		curr: nil,
-		pos:  -1,
-		N:    tree.Size(),
+		root:     *tree.rootPtr,
+		curr:     nil,
+		pos:      -1,
+		N:        tree.Size(),
+		less:     tree.Less,
+		tree:     tree,
+		modCount: tree.modCount,
 	}
 	initialize(it)
 	return it
@@ -865,17 +2974,72 @@ func NewIterator(tree *Treap) *Iterator {
 
 func NewReverseIterator(tree *Treap) *Iterator {
 	it := &Iterator{
-		root: *tree.rootPtr,
-		curr: nil,
-		pos:  -1,
-		N:    tree.Size(),
+		root:     *tree.rootPtr,
+		curr:     nil,
+		pos:      -1,
+		N:        tree.Size(),
+		less:     tree.Less,
+		tree:     tree,
+		modCount: tree.modCount,
 	}
 
 	return it.ResetLast()
 }
 
+// NewIteratorAt Return an iterator positioned on the key at the inorder position pos, which is
+// reached in O(log n) expected time. The subsequent advances are O(1) amortized. Panic if pos is not
+// in [0, Size)
+func NewIteratorAt(tree *Treap, pos int) *Iterator {
+
+	if pos < 0 || pos >= tree.Size() {
+		panic(fmt.Sprintf("Position %d out of range", pos))
+	}
+
+	it := &Iterator{
+		root:     *tree.rootPtr,
+		curr:     nil,
+		pos:      -1,
+		N:        tree.Size(),
+		less:     tree.Less,
+		tree:     tree,
+		modCount: tree.modCount,
+	}
+	it.setPos(pos)
+
+	return it
+}
+
+// NewRangeIterator Return an iterator on the keys of tree contained in [lo, hi]. The iterator
+// starts on the first key greater or equal than lo. Keys greater than hi are treated as past
+// the end and keys smaller than lo as before the first item, so ResetFirst goes back to the
+// first key of the range. If the range is empty, the iterator has not current item
+func NewRangeIterator(tree *Treap, lo, hi interface{}) *Iterator {
+	it := &Iterator{
+		root:     *tree.rootPtr,
+		curr:     nil,
+		N:        __countLessOrEqual(*tree.rootPtr, hi, tree.Less),
+		less:     tree.Less,
+		tree:     tree,
+		modCount: tree.modCount,
+	}
+	it.lo = __countLess(it.root, lo, it.less)
+	if it.lo > it.N {
+		it.lo = it.N
+	}
+	it.setPos(it.lo)
+
+	return it
+}
+
+// Helper that panics if the tree was structurally modified after the creation of the iterator
+func (it *Iterator) checkModCount() {
+	if it.tree != nil && it.tree.modCount != it.modCount {
+		panic("Iterator invalidated by concurrent modification of the tree")
+	}
+}
+
 // Reset the iterator to the first item of the set
-func (it *Iterator) ResetFirst() interface{} {
+func (it *Iterator) ResetFirst() *Iterator {
 	initialize(it)
 	return it
 }
@@ -885,29 +3049,74 @@ func (it *Iterator) ResetLast() *Iterator {
 	if it.N == 0 {
 		panic("Tree is empty")
 	}
+	if it.N == it.lo {
+		panic("Iterator range is empty")
+	}
 	it.pos = it.N - 1
-	it.curr = __choose(it.root, it.pos)
+	it.stack = __predecessorStack(it.root, it.pos)
+	it.backward = true
+	it.curr = it.stack[len(it.stack)-1].node
+
+	return it
+}
+
+// Seek Position the iterator on the smallest key greater or equal than key. If there is not such
+// key, then the iterator has not current item. A range iterator is not moved before the first key
+// of its range. The positioning spends O(log n) expected time
+func (it *Iterator) Seek(key interface{}) *Iterator {
+
+	it.checkModCount()
+
+	pos := __countLess(it.root, key, it.less)
+	if pos < it.lo {
+		pos = it.lo
+	} else if pos > it.N {
+		pos = it.N
+	}
+	it.setPos(pos)
 
 	return it
 }
 
-func (it *Iterator) getPos() int { return it.pos }
+// Clone Return an independent iterator positioned on the same item of the same tree
+func (it *Iterator) Clone() *Iterator {
+	ret := *it
+	if it.stack != nil {
+		ret.stack = append(make([]iteratorFrame, 0, cap(it.stack)), it.stack...)
+	}
+	return &ret
+}
+
+// GetPos Return the inorder position of the current item. It returns the position preceding the
+// first item (-1, unless the iterator is a range one) if the iterator is before the first item and
+// N if it is past the end
+func (it *Iterator) GetPos() int { return it.pos }
 
 // Return true if iterator is positioned on an item. Otherwise it return false
 func (it *Iterator) HasCurr() bool {
-	return it.pos >= 0 && it.pos < it.N
+	return it.pos >= it.lo && it.pos < it.N
 }
 
 // Return the current item on which the iterator is positioned. Panic if there is not current item
 func (it *Iterator) GetCurr() interface{} {
+	it.checkModCount()
 	if !it.HasCurr() {
 		panic("Iterator has not current item")
 	}
 	return it.curr.key
 }
 
-// Advance iterator to the next item in the ordered sequence
-func (it *Iterator) Next() interface{} {
+// GetCurrWithPos Return the current item and its inorder position, which is tracked by the
+// iterator, so no rank computation is done. Panic if there is not current item
+func (it *Iterator) GetCurrWithPos() (interface{}, int) {
+	return it.GetCurr(), it.pos
+}
+
+// Advance iterator to the next item in the ordered sequence. The advance spends O(1) amortized
+// time, unless the iterator was previously moved by Prev, Seek or ResetLast. In this case, the
+// first advance spends O(log n)
+func (it *Iterator) Next() *Iterator {
+	it.checkModCount()
 	if it.pos == it.N {
 		panic("Iterator overflow")
 	}
@@ -915,26 +3124,55 @@ func (it *Iterator) Next() interface{} {
 	it.pos++
 	if it.pos == it.N {
 		it.curr = nullNodePtr
+		it.stack = nil
 		return it
 	}
 
-	it.curr = __choose(it.root, it.pos)
+	if it.stack == nil || it.backward {
+		it.stack = __successorStack(it.root, it.pos)
+		it.backward = false
+	} else {
+		top := it.stack[len(it.stack)-1]
+		r := top.node.rlink
+		if top.rev {
+			r = top.node.llink
+		}
+		it.stack = __pushLeftPath(it.stack[:len(it.stack)-1], r, top.rev)
+	}
+
+	it.curr = it.stack[len(it.stack)-1].node
 	return it
 }
 
-// Advance iterator to the previous item in the ordered sequence
+// Advance iterator to the previous item in the ordered sequence. The advance spends O(1)
+// amortized time, unless the iterator was previously moved by Next, Seek or ResetFirst. In this
+// case, the first advance spends O(log n)
 func (it *Iterator) Prev() *Iterator {
-	if it.pos == -1 {
+	it.checkModCount()
+	if it.pos < it.lo {
 		panic("Iterator underflow")
 	}
 
 	it.pos--
-	if it.pos == -1 {
+	if it.pos < it.lo {
 		it.curr = nullNodePtr
+		it.stack = nil
 		return it
 	}
 
-	it.curr = __choose(it.root, it.pos)
+	if it.stack == nil || !it.backward {
+		it.stack = __predecessorStack(it.root, it.pos)
+		it.backward = true
+	} else {
+		top := it.stack[len(it.stack)-1]
+		l := top.node.llink
+		if top.rev {
+			l = top.node.rlink
+		}
+		it.stack = __pushRightPath(it.stack[:len(it.stack)-1], l, top.rev)
+	}
+
+	it.curr = it.stack[len(it.stack)-1].node
 	return it
 }
 
@@ -954,27 +3192,48 @@ func (tree *Treap) Traverse(operation func(key interface{}) bool) bool {
 	return true
 }
 
-// Simple BST checker; Not completely correct
-func checkBST(node *Node, less func(i1, i2 interface{}) bool) bool {
+// TraverseReverse Equivalent to Traverse, but the keys are visited in descending order
+func (tree *Treap) TraverseReverse(operation func(key interface{}) bool) bool {
 
-	if node == nullNodePtr {
+	if tree.IsEmpty() {
 		return true
 	}
 
-	if node.llink != nullNodePtr {
-		if !less(node.llink.key, node.key) && !__equal(node.llink.key, node.key, less) {
-			return false
-		}
-		if !checkBST(node.llink, less) {
+	for it := NewReverseIterator(tree); it.HasCurr(); it.Prev() {
+		if !operation(it.GetCurr()) {
 			return false
 		}
 	}
 
-	if node.rlink != nullNodePtr {
-		if !less(node.key, node.rlink.key) && !__equal(node.key, node.rlink.key, less) {
+	return true
+}
+
+// ForEachGroup Traverse inorder the tree and execute operation once per distinct key, along with
+// the number of times that the key is repeated. The key passed is the first of its run. The
+// traversal stops if operation returns false. Return true if all the groups were visited
+func (tree *Treap) ForEachGroup(operation func(key interface{}, count int) bool) bool {
+
+	it := NewIterator(tree)
+	for it.HasCurr() {
+		key := it.GetCurr()
+		count := 1
+		for it.Next(); it.HasCurr() && __equal(key, it.GetCurr(), tree.Less); it.Next() {
+			count++
+		}
+		if !operation(key, count) {
 			return false
 		}
-		if !checkBST(node.rlink, less) {
+	}
+
+	return true
+}
+
+// TraverseRange Equivalent to Traverse, but only the keys in [lo, hi] are visited. The traversal
+// starts by seeking lo in O(log n) and it stops when the keys exceed hi
+func (tree *Treap) TraverseRange(lo, hi interface{}, operation func(key interface{}) bool) bool {
+
+	for it := NewRangeIterator(tree, lo, hi); it.HasCurr(); it.Next() {
+		if !operation(it.GetCurr()) {
 			return false
 		}
 	}
@@ -982,6 +3241,440 @@ func checkBST(node *Node, less func(i1, i2 interface{}) bool) bool {
 	return true
 }
 
+// ToSlice Return a slice with the keys of tree in ascending order
+func (tree *Treap) ToSlice() []interface{} {
+
+	keys := make([]interface{}, 0, tree.Size())
+	tree.Traverse(func(key interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	return keys
+}
+
+// sort.Interface on a slice of keys compared through the less function of a tree
+type sortableKeys struct {
+	keys []interface{}
+	less func(i1, i2 interface{}) bool
+}
+
+func (s *sortableKeys) Len() int           { return len(s.keys) }
+func (s *sortableKeys) Less(i, j int) bool { return s.less(s.keys[i], s.keys[j]) }
+func (s *sortableKeys) Swap(i, j int)      { s.keys[i], s.keys[j] = s.keys[j], s.keys[i] }
+
+// AsSortable Return a sort.Interface on a slice materialized with ToSlice. Less delegates to
+// tree.Less, while Swap operates on the slice; so the tree is never modified
+func (tree *Treap) AsSortable() sort.Interface {
+	return &sortableKeys{
+		keys: tree.ToSlice(),
+		less: tree.Less,
+	}
+}
+
+// Map Return a new treap ordered by less containing the result of applying f to every key of
+// tree. If dup is true, then the keys mapped to equal values are all kept (InsertDup); otherwise
+// only the first one in the order of tree is kept (Insert). tree is not modified
+func (tree *Treap) Map(f func(key interface{}) interface{}, less func(i1, i2 interface{}) bool,
+	dup bool) *Treap {
+
+	ret := New(tree.seed, less)
+	tree.Traverse(func(key interface{}) bool {
+		if dup {
+			ret.InsertDup(f(key))
+		} else {
+			ret.Insert(f(key))
+		}
+		return true
+	})
+
+	return ret
+}
+
+// Filter Return a new treap with the same order, priority function, key type and allocator than
+// tree containing the keys of tree that satisfy pred. Duplicated keys are kept. Since the keys are
+// visited in order, the result is built in linear time. tree is not modified
+func (tree *Treap) Filter(pred func(key interface{}) bool) *Treap {
+
+	keys := make([]interface{}, 0)
+	tree.Traverse(func(key interface{}) bool {
+		if pred(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	return tree.newFromSorted(keys)
+}
+
+// Partition Distribute the keys of tree into matching, with the keys satisfying pred, and rest, with
+// the remaining ones. pred does not need to be monotone respect to the order. After operation tree
+// becomes empty, as in Intersection. The nodes are moved, keeping their priorities, and both trees
+// are rebuilt from their inorder sequences, so the complexity is O(n)
+func (tree *Treap) Partition(pred func(key interface{}) bool) (matching, rest *Treap) {
+
+	tree.modified()
+	matching = tree.newLike(tree.seed)
+	rest = tree.newLike(tree.seed)
+
+	matchingSpine := make([]*Node, 0, 64)
+	restSpine := make([]*Node, 0, 64)
+	for _, p := range __inorderNodes(*tree.rootPtr, false, make([]*Node, 0, tree.Size())) {
+		p.reset()
+		if pred(p.key) {
+			matchingSpine = __appendToSpine(matchingSpine, p)
+		} else {
+			restSpine = __appendToSpine(restSpine, p)
+		}
+	}
+
+	*matching.rootPtr = __spineRoot(matchingSpine)
+	*rest.rootPtr = __spineRoot(restSpine)
+	*tree.rootPtr = nullNodePtr
+
+	return
+}
+
+// Rebalance Rebuild the tree in O(n) with new priorities, drawn as for an insertion, keeping
+// exactly the same keys. The nodes are reused; they are linked again from the inorder sequence as a
+// Cartesian tree. It allows to recover the expected O(log n) height of a degenerate tree, for
+// example one built with a bad priority function
+func (tree *Treap) Rebalance() {
+
+	tree.modified()
+	spine := make([]*Node, 0, 64)
+	for _, p := range __inorderNodes(*tree.rootPtr, false, make([]*Node, 0, tree.Size())) {
+		p.reset()
+		p.priority = tree.newPriority(p.key)
+		spine = __appendToSpine(spine, p)
+	}
+
+	*tree.rootPtr = __spineRoot(spine)
+}
+
+// Fold Walk the keys in ascending order threading an accumulator through f, whose first value is
+// initial. Return the last accumulator. tree is not modified
+func (tree *Treap) Fold(initial interface{}, f func(acc, key interface{}) interface{}) interface{} {
+
+	acc := initial
+	tree.Traverse(func(key interface{}) bool {
+		acc = f(acc, key)
+		return true
+	})
+
+	return acc
+}
+
+// FoldRight Equivalent to Fold, but the keys are walked in descending order
+func (tree *Treap) FoldRight(initial interface{},
+	f func(acc, key interface{}) interface{}) interface{} {
+
+	acc := initial
+	tree.TraverseReverse(func(key interface{}) bool {
+		acc = f(acc, key)
+		return true
+	})
+
+	return acc
+}
+
+// Any Return true as soon as a key satisfies pred. It returns false on an empty set
+func (tree *Treap) Any(pred func(key interface{}) bool) bool {
+
+	return !tree.Traverse(func(key interface{}) bool {
+		return !pred(key)
+	})
+}
+
+// All Return false as soon as a key does not satisfy pred. It returns true on an empty set
+func (tree *Treap) All(pred func(key interface{}) bool) bool {
+	return tree.Traverse(pred)
+}
+
+// Helper that computes the height of tree root
+func __height(root *Node) int {
+
+	if root == nullNodePtr {
+		return 0
+	}
+
+	l, r := __height(root.llink), __height(root.rlink)
+	if l > r {
+		return l + 1
+	}
+	return r + 1
+}
+
+// Height Return the height of the tree; 0 if it is empty, 1 if it has a single node
+func (tree *Treap) Height() int {
+	return __height(*tree.rootPtr)
+}
+
+// NodeCount Return the number of nodes held by the tree. That is Size() plus the nodes waiting in
+// the free list of a pooled tree. The empty tree sentinel is shared by every treap, so it is not
+// counted
+func (tree *Treap) NodeCount() int {
+	return tree.Size() + len(tree.freeList)
+}
+
+// ApproxMemoryBytes Return an estimate of the heap bytes used by the tree: the treap header plus
+// NodeCount() nodes plus the free list backing array. Keys are counted as interface headers
+// (two words each); the values they point to are not included. Nodes shared with snapshots are
+// counted by every tree that reaches them
+func (tree *Treap) ApproxMemoryBytes() int {
+	var p *Node
+	return int(unsafe.Sizeof(*tree)) + tree.NodeCount()*int(unsafe.Sizeof(*p)) +
+		cap(tree.freeList)*int(unsafe.Sizeof(p))
+}
+
+// Helper that computes the sum of the depths of every node of tree root, whose depth is depth
+func __internalPathLength(root *Node, depth int) int {
+
+	if root == nullNodePtr {
+		return 0
+	}
+
+	return depth + __internalPathLength(root.llink, depth+1) +
+		__internalPathLength(root.rlink, depth+1)
+}
+
+// AverageDepth Return the mean number of edges from the root to a node. It returns 0 if the tree
+// is empty. In a treap it is expected to be O(log n)
+func (tree *Treap) AverageDepth() float64 {
+
+	if tree.IsEmpty() {
+		return 0
+	}
+
+	return float64(__internalPathLength(*tree.rootPtr, 0)) / float64(tree.Size())
+}
+
+// DepthOf Return the number of edges from the root to the node containing key. The pair
+// (depth, true) is returned if the key is found. Otherwise, (0, false) is returned
+func (tree *Treap) DepthOf(key interface{}) (int, bool) {
+
+	depth := 0
+	for root := *tree.rootPtr; root != nullNodePtr; depth++ {
+		if tree.Less(key, root.key) {
+			root = root.llink
+		} else if tree.Less(root.key, key) {
+			root = root.rlink
+		} else {
+			return depth, true
+		}
+	}
+
+	return 0, false
+}
+
+// String Return the inorder listing of the keys between brackets; e.g. [1 2 3]
+func (tree *Treap) String() string {
+
+	var builder strings.Builder
+	builder.WriteString("[")
+	for it := NewIterator(tree); it.HasCurr(); it.Next() {
+		if it.GetPos() > 0 {
+			builder.WriteString(" ")
+		}
+		builder.WriteString(fmt.Sprint(it.GetCurr()))
+	}
+	builder.WriteString("]")
+
+	return builder.String()
+}
+
+// Helper that writes in builder the DOT nodes and edges of tree root. Nodes are named according
+// to their preorder position, which starts at id. Return the next free id
+func __toDOT(root *Node, id int, builder *strings.Builder) int {
+
+	builder.WriteString(fmt.Sprintf("  n%d [label=\"%v\\np=%d\\nc=%d\"];\n",
+		id, root.key, root.priority, root.count))
+
+	next := id + 1
+	if root.llink != nullNodePtr {
+		builder.WriteString(fmt.Sprintf("  n%d -> n%d;\n", id, next))
+		next = __toDOT(root.llink, next, builder)
+	}
+	if root.rlink != nullNodePtr {
+		builder.WriteString(fmt.Sprintf("  n%d -> n%d;\n", id, next))
+		next = __toDOT(root.rlink, next, builder)
+	}
+
+	return next
+}
+
+// ToDOT Return a Graphviz DOT representation of the tree. Every node is labeled with its key,
+// priority and count. The output is deterministic for a given tree, so it can be rendered with
+// dot -Tpng
+func (tree *Treap) ToDOT() string {
+
+	var builder strings.Builder
+	builder.WriteString("digraph treap {\n")
+	if *tree.rootPtr != nullNodePtr {
+		__toDOT(*tree.rootPtr, 0, &builder)
+	}
+	builder.WriteString("}\n")
+
+	return builder.String()
+}
+
+// Helper that verifies that every key of tree root is inside [low.key, high.key]. A nil bound means
+// that the interval is not bounded on that side. Bounds are closed because duplicated keys can
+// be at both sides of an equal ancestor
+func __validateBST(root, low, high *Node, less func(i1, i2 interface{}) bool) error {
+
+	if root == nullNodePtr {
+		return nil
+	}
+
+	if low != nil && less(root.key, low.key) {
+		return fmt.Errorf("BST order violated: key %v is less than its ancestor %v", root.key, low.key)
+	}
+
+	if high != nil && less(high.key, root.key) {
+		return fmt.Errorf("BST order violated: key %v is greater than its ancestor %v",
+			root.key, high.key)
+	}
+
+	if err := __validateBST(root.llink, low, root, less); err != nil {
+		return err
+	}
+
+	return __validateBST(root.rlink, root, high, less)
+}
+
+// Helper that verifies that the priority of every node of tree root is not greater than the
+// priorities of its children
+func __validateHeap(root *Node) error {
+
+	if root == nullNodePtr {
+		return nil
+	}
+
+	if root.priority > root.llink.priority || root.priority > root.rlink.priority {
+		return fmt.Errorf("heap order violated: key %v has priority %d greater than a child's one",
+			root.key, root.priority)
+	}
+
+	if err := __validateHeap(root.llink); err != nil {
+		return err
+	}
+
+	return __validateHeap(root.rlink)
+}
+
+// Helper that verifies that the count of every node of tree root is the number of nodes of its
+// subtree
+func __validateCount(root *Node) error {
+
+	if root == nullNodePtr {
+		return nil
+	}
+
+	if root.llink.count+1+root.rlink.count != root.count {
+		return fmt.Errorf("count violated: key %v has count %d but its subtree has %d nodes",
+			root.key, root.count, root.llink.count+1+root.rlink.count)
+	}
+
+	if err := __validateCount(root.llink); err != nil {
+		return err
+	}
+
+	return __validateCount(root.rlink)
+}
+
+// Validate Verify the tree invariants: BST order of the keys respect to Less, heap order of the
+// priorities and consistency of the subtree counters. Return nil if all the invariants hold.
+// Otherwise, it returns an error describing the first violated invariant and the offending key
+func (tree *Treap) Validate() error {
+
+	if !checkSentinel() {
+		return errors.New("the sentinel node representing the empty tree was modified")
+	}
+
+	root := *tree.rootPtr
+	if err := __validateBST(root, nil, nil, tree.Less); err != nil {
+		return err
+	}
+
+	if err := __validateHeap(root); err != nil {
+		return err
+	}
+
+	return __validateCount(root)
+}
+
+// Helper that returns the number of nodes of tree root, counted by walking it, or an error for the
+// first node in postorder whose count differs from the walked size
+func __checkCounts(root *Node) (int, error) {
+
+	if root == nullNodePtr {
+		return 0, nil
+	}
+
+	l, err := __checkCounts(root.llink)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := __checkCounts(root.rlink)
+	if err != nil {
+		return 0, err
+	}
+
+	if size := l + 1 + r; size != root.count {
+		return 0, fmt.Errorf("count violated: key %v has count %d but its subtree has %d nodes",
+			root.key, root.count, size)
+	}
+
+	return root.count, nil
+}
+
+// CheckCounts Verify that the count of every node is the size of its subtree, which is computed by
+// walking the subtree instead of trusting the counts of the children. Return an error with the key
+// of the first mismatched node or nil if all the counts are right. Complexity is O(n)
+func (tree *Treap) CheckCounts() error {
+	_, err := __checkCounts(*tree.rootPtr)
+	return err
+}
+
+// HasDuplicates Return true if some key is stored more than once. The keys are scanned in order
+// until the first repetition, so the complexity is O(n). It complements Validate, which does not
+// check uniqueness
+func (tree *Treap) HasDuplicates() bool {
+
+	var last interface{}
+	first := true
+	return !tree.Traverse(func(key interface{}) bool {
+		repeated := !first && !tree.Less(last, key)
+		last, first = key, false
+		return !repeated
+	})
+}
+
+// Duplicates Return in ascending order the keys stored more than once. Every repeated key is
+// reported once, through its first copy in order. The complexity is O(n)
+func (tree *Treap) Duplicates() []interface{} {
+
+	ret := make([]interface{}, 0)
+	for it := NewIterator(tree); it.HasCurr(); {
+		key := it.GetCurr()
+		it.Next()
+		if it.HasCurr() && !tree.Less(key, it.GetCurr()) {
+			ret = append(ret, key)
+			__skipEqual(it, tree.Less)
+		}
+	}
+
+	return ret
+}
+
+// BST checker. Every key is verified against the (low, high) bounds inherited from its ancestors,
+// not only against its children
+func checkBST(node *Node, less func(i1, i2 interface{}) bool) bool {
+	return __validateBST(node, nil, nil, less) == nil
+}
+
 // Simple priority checker
 func checkTreap(node *Node) bool {
 
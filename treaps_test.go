@@ -1,10 +1,15 @@
 package treaps
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"math/bits"
 	"math/rand"
+	"sort"
 	"testing"
+	"unsafe"
 )
 
 func cmpInt(i1, i2 interface{}) bool {
@@ -217,11 +222,11 @@ func TestTreap_splitPos(t *testing.T) {
 	assert.Equal(t, min2, t2.Min())
 	assert.Equal(t, max2, t2.Max())
 
-	for i, it := 0, NewIterator(t1); it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for i, it := 0, NewIterator(t1); it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr().(int))
 	}
 
-	for i, it := N/2+1, NewIterator(t2); it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for i, it := N/2+1, NewIterator(t2); it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr().(int))
 	}
 
@@ -230,11 +235,11 @@ func TestTreap_splitPos(t *testing.T) {
 	assert.True(t, t0.check())
 	assert.True(t, t1.check())
 
-	for i, it := 0, NewIterator(t0); it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for i, it := 0, NewIterator(t0); it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr())
 	}
 
-	for i, it := 1, NewIterator(t1); it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for i, it := 1, NewIterator(t1); it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr())
 	}
 }
@@ -251,7 +256,7 @@ func TestTreap_SplitByPositionCorners(t *testing.T) {
 	assert.True(t, t2.check())
 	assert.Equal(t, N, t1.Size())
 	assert.Equal(t, 0, t2.Size())
-	for i, it := 0, NewIterator(t1); i < N; i, it = i+1, it.Next().(*Iterator) {
+	for i, it := 0, NewIterator(t1); i < N; i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr())
 	}
 
@@ -261,7 +266,7 @@ func TestTreap_SplitByPositionCorners(t *testing.T) {
 	assert.Equal(t, 1, t1.Size())
 	assert.Equal(t, N-1, t2.Size())
 	assert.Equal(t, 0, t1.Min())
-	for i, it := 1, NewIterator(t2); it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for i, it := 1, NewIterator(t2); it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr())
 	}
 
@@ -271,7 +276,7 @@ func TestTreap_SplitByPositionCorners(t *testing.T) {
 	assert.Equal(t, 1, t1.Size())
 	assert.Equal(t, 1, t1.Min())
 	assert.Equal(t, N-2, t2.Size())
-	for i, it := 2, NewIterator(t2); it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for i, it := 2, NewIterator(t2); it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr())
 	}
 
@@ -354,7 +359,7 @@ func TestTreap_IteratorNext(t *testing.T) {
 	}
 
 	i, it := 0, NewIterator(tree)
-	for ; it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for ; it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr())
 	}
 	assert.Equal(t, i, N)
@@ -378,7 +383,7 @@ func TestNewReverseIterator(t *testing.T) {
 	}
 	assert.Equal(t, i, -1)
 
-	for i, it = 0, it.Next().(*Iterator); it.HasCurr(); i, it = i+1, it.Next().(*Iterator) {
+	for i, it = 0, it.Next(); it.HasCurr(); i, it = i+1, it.Next() {
 		assert.Equal(t, i, it.GetCurr())
 	}
 	assert.Equal(t, i, N)
@@ -455,6 +460,29 @@ func Test_checkBST(t *testing.T) {
 	assert.False(t, checkBST(root, cmpInt))
 }
 
+func Test_checkBSTTransitive(t *testing.T) {
+
+	// every node is correct respect to its children, but 12 is in the left subtree of 10
+	root := &Node{
+		key: 10,
+		llink: &Node{
+			key:   5,
+			llink: nullNodePtr,
+			rlink: &Node{
+				key:   12,
+				llink: nullNodePtr,
+				rlink: nullNodePtr,
+			},
+		},
+		rlink: nullNodePtr,
+	}
+
+	assert.False(t, checkBST(root, cmpInt))
+
+	root.llink.rlink.key = 7
+	assert.True(t, checkBST(root, cmpInt))
+}
+
 func TestTreap_SimpleIntersection(t *testing.T) {
 
 	t1 := New(1, cmpInt, 1, 3, 5, 7, 9, 10, 11, 13, 15, 17, 19)
@@ -632,3 +660,2859 @@ func TestTreap_Has(t *testing.T) {
 		return tree.Has(key)
 	}), "Every key should return true when called on Has")
 }
+
+func TestFromSortedSlice(t *testing.T) {
+
+	const N = 1000
+	items := make([]interface{}, 0, N)
+	for i := 0; i < N; i++ {
+		items = append(items, i/2) // keys are duplicated
+	}
+
+	tree := FromSortedSlice(1, cmpInt, items)
+
+	assert.True(t, tree.check())
+	assert.Equal(t, N, tree.Size())
+	for i, it := 0, NewIterator(tree); it.HasCurr(); i, it = i+1, it.Next() {
+		assert.Equal(t, i/2, it.GetCurr())
+	}
+
+	empty := FromSortedSlice(1, cmpInt, []interface{}{})
+	assert.True(t, empty.check())
+	assert.True(t, empty.IsEmpty())
+
+	assert.Panics(t, func() {
+		FromSortedSlice(1, cmpInt, []interface{}{1, 2, 4, 3, 5})
+	})
+}
+
+func TestTreap_UnionCopy(t *testing.T) {
+
+	t1 := New(1, cmpInt, 1, 3, 5, 7, 9, 9, 10)
+	t2 := New(2, cmpInt, 2, 4, 6, 8, 9, 10, 12)
+	c1, c2 := t1.Copy(), t2.Copy()
+
+	u := t1.UnionCopy(t2)
+
+	assert.True(t, u.check())
+	assert.Equal(t, 0, u.lexicographicCmp(New(3, cmpInt, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 12)))
+	assert.True(t, t1.TopologicalEqual(c1))
+	assert.True(t, t2.TopologicalEqual(c2))
+	assert.True(t, t1.check())
+	assert.True(t, t2.check())
+
+	assert.Equal(t, 0, NewTreap(cmpInt).UnionCopy(NewTreap(cmpInt)).Size())
+}
+
+func TestTreap_Difference(t *testing.T) {
+
+	t1 := New(1, cmpInt, 1, 3, 5, 7, 9, 10, 11)
+	t2 := New(2, cmpInt, 2, 4, 6, 8, 9, 10, 12)
+	c1, c2 := t1.Copy(), t2.Copy()
+
+	d := t1.Difference(t2)
+	assert.True(t, d.check())
+	assert.Equal(t, 0, d.lexicographicCmp(NewTreap(cmpInt, 1, 3, 5, 7, 11)))
+
+	d = t2.Difference(t1)
+	assert.True(t, d.check())
+	assert.Equal(t, 0, d.lexicographicCmp(NewTreap(cmpInt, 2, 4, 6, 8, 12)))
+
+	assert.True(t, t1.TopologicalEqual(c1))
+	assert.True(t, t2.TopologicalEqual(c2))
+
+	assert.Equal(t, 0, t1.Difference(t1).Size())
+	assert.Equal(t, 0, NewTreap(cmpInt).Difference(t1).Size())
+	assert.Equal(t, 0, t1.Difference(NewTreap(cmpInt)).lexicographicCmp(t1))
+}
+
+func TestTreap_SymmetricDifference(t *testing.T) {
+
+	const N = 1000
+	t1, t2 := New(1, cmpInt), New(2, cmpInt)
+	insertNRandomItems(t1, N)
+	insertNRandomItems(t2, N)
+	c1, c2 := t1.Copy(), t2.Copy()
+
+	d := t1.SymmetricDifference(t2)
+
+	assert.True(t, d.check())
+	assert.True(t, t1.TopologicalEqual(c1))
+	assert.True(t, t2.TopologicalEqual(c2))
+
+	for it := NewIterator(d); it.HasCurr(); it.Next() {
+		assert.True(t, t1.Has(it.GetCurr()) != t2.Has(it.GetCurr()))
+	}
+
+	inter, _, _ := c1.Intersection(c2)
+	assert.Equal(t, t1.Size()+t2.Size()-2*inter.Size(), d.Size())
+}
+
+func TestTreap_IsSubsetOf(t *testing.T) {
+
+	t1 := NewTreap(cmpInt, 1, 3, 5)
+	t2 := NewTreap(cmpInt, 1, 2, 3, 4, 5)
+	empty := NewTreap(cmpInt)
+	c1, c2 := t1.Copy(), t2.Copy()
+
+	assert.True(t, t1.IsSubsetOf(t2))
+	assert.False(t, t2.IsSubsetOf(t1))
+	assert.True(t, t1.IsSubsetOf(t1))
+	assert.True(t, empty.IsSubsetOf(t1))
+	assert.True(t, empty.IsSubsetOf(empty))
+	assert.False(t, t1.IsSubsetOf(empty))
+
+	assert.True(t, t2.IsSupersetOf(t1))
+	assert.False(t, t1.IsSupersetOf(t2))
+	assert.True(t, t1.IsSupersetOf(empty))
+
+	assert.True(t, t1.TopologicalEqual(c1))
+	assert.True(t, t2.TopologicalEqual(c2))
+}
+
+func TestTreap_Equal(t *testing.T) {
+
+	t1 := New(1, cmpInt, 1, 2, 3, 4, 5, 5)
+	t2 := New(2, cmpInt, 5, 4, 5, 3, 2, 1)
+
+	assert.True(t, t1.Equal(t2))
+	assert.True(t, t2.Equal(t1))
+	assert.True(t, NewTreap(cmpInt).Equal(NewTreap(cmpInt)))
+
+	assert.False(t, t1.Equal(NewTreap(cmpInt, 1, 2, 3, 4, 5)))
+	assert.False(t, t1.Equal(NewTreap(cmpInt, 1, 2, 3, 4, 5, 6)))
+	assert.False(t, t1.Equal(NewTreap(cmpInt)))
+}
+
+func TestTreap_Compare(t *testing.T) {
+
+	sets := []*Treap{
+		NewTreap(cmpInt, 2, 3, 4),
+		NewTreap(cmpInt, 1, 2),
+		NewTreap(cmpInt),
+		NewTreap(cmpInt, 1, 2, 3),
+		NewTreap(cmpInt, 1),
+	}
+
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Compare(sets[j]) < 0 })
+
+	assert.Equal(t, 0, sets[0].Compare(NewTreap(cmpInt)))
+	assert.Equal(t, 0, sets[1].Compare(NewTreap(cmpInt, 1)))
+	assert.Equal(t, 0, sets[2].Compare(NewTreap(cmpInt, 1, 2)))
+	assert.Equal(t, 0, sets[3].Compare(NewTreap(cmpInt, 1, 2, 3)))
+	assert.Equal(t, 0, sets[4].Compare(NewTreap(cmpInt, 2, 3, 4)))
+	assert.Equal(t, 1, sets[4].Compare(sets[0]))
+	assert.Equal(t, -1, sets[0].Compare(sets[4]))
+}
+
+func TestTreap_RangeCount(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(2 * i) // only even keys
+	}
+
+	assert.Equal(t, N, tree.RangeCount(0, 2*N))
+	assert.Equal(t, N, tree.RangeCount(-10, 2*N+10))
+	assert.Equal(t, 1, tree.RangeCount(10, 10))
+	assert.Equal(t, 0, tree.RangeCount(11, 11))
+	assert.Equal(t, 6, tree.RangeCount(10, 20))
+	assert.Equal(t, 5, tree.RangeCount(9, 19))
+	assert.Equal(t, 0, tree.RangeCount(20, 10))
+	assert.Equal(t, 0, NewTreap(cmpInt).RangeCount(0, 10))
+
+	tree.InsertDup(10)
+	tree.InsertDup(10)
+	assert.Equal(t, 3, tree.RangeCount(10, 10))
+	assert.Equal(t, 8, tree.RangeCount(10, 20))
+}
+
+func TestTreap_CountLessAndGreater(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 2, 4, 4, 4, 6, 8)
+
+	assert.Equal(t, 0, tree.CountLess(2))
+	assert.Equal(t, 1, tree.CountLess(3))
+	assert.Equal(t, 1, tree.CountLess(4))
+	assert.Equal(t, 4, tree.CountLess(5))
+	assert.Equal(t, 6, tree.CountLess(100))
+	assert.Equal(t, 0, tree.CountLess(-100))
+
+	assert.Equal(t, 6, tree.CountGreater(0))
+	assert.Equal(t, 2, tree.CountGreater(4))
+	assert.Equal(t, 2, tree.CountGreater(5))
+	assert.Equal(t, 0, tree.CountGreater(8))
+
+	for _, key := range []int{0, 2, 3, 4, 7, 8, 9} {
+		assert.Equal(t, tree.Size(), tree.CountLess(key)+tree.RangeCount(key, key)+tree.CountGreater(key))
+	}
+
+	assert.Equal(t, 0, NewTreap(cmpInt).CountLess(1))
+	assert.Equal(t, 0, NewTreap(cmpInt).CountGreater(1))
+}
+
+func TestTreap_ExtractMinMax(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	for i := 0; i < N/2; i++ {
+		assert.Equal(t, i, tree.ExtractMin())
+		assert.True(t, tree.check())
+		assert.Equal(t, N-1-i, tree.ExtractMax())
+		assert.True(t, tree.check())
+		assert.Equal(t, N-2*(i+1), tree.Size())
+	}
+
+	assert.True(t, tree.IsEmpty())
+	assert.Nil(t, tree.ExtractMin())
+	assert.Nil(t, tree.ExtractMax())
+}
+
+// Recursive reference insertion used for checking that the iterative one builds the same tree
+func insertNodeRecursive(root, p *Node, less func(i1, i2 interface{}) bool) *Node {
+
+	if root == nullNodePtr {
+		return p
+	}
+
+	if less(p.key, root.key) {
+		root.llink = insertNodeRecursive(root.llink, p, less)
+		root.count++
+		if root.llink.priority < root.priority {
+			root = rotateRight(root)
+		}
+		return root
+	}
+
+	root.rlink = insertNodeRecursive(root.rlink, p, less)
+	root.count++
+	if root.rlink.priority < root.priority {
+		root = rotateLeft(root)
+	}
+	return root
+}
+
+func TestTreap_IterativeInsertTopology(t *testing.T) {
+
+	const N = 1000
+	t1, t2 := New(5, cmpInt), New(5, cmpInt)
+	for i := 0; i < N; i++ {
+		key := rand.Intn(N / 2) // with duplicates
+		t1.InsertDup(key)
+		p := &Node{
+			key:      key,
+			priority: t2.randGenerator.Uint64(),
+			count:    1,
+			llink:    nullNodePtr,
+			rlink:    nullNodePtr,
+		}
+		*t2.rootPtr = insertNodeRecursive(*t2.rootPtr, p, cmpInt)
+	}
+
+	assert.True(t, t1.check())
+	assert.True(t, t2.check())
+	assert.True(t, t1.TopologicalEqual(t2))
+}
+
+func TestTreap_InsertDegenerated(t *testing.T) {
+
+	// priorities increasing with the keys produce a tree degenerated in a list
+	const N = 10000
+	tree := New(1, cmpInt)
+	for i := 0; i < N; i++ {
+		p := &Node{
+			key:      i,
+			priority: uint64(i),
+			count:    1,
+			llink:    nullNodePtr,
+			rlink:    nullNodePtr,
+		}
+		*tree.rootPtr = __insertNode(*tree.rootPtr, p, tree.cmp)
+	}
+
+	assert.Equal(t, N, tree.Size())
+	assert.True(t, tree.check())
+}
+
+func TestTreap_SetPriorityFunc(t *testing.T) {
+
+	const N = 1000
+	keyPriority := func(key interface{}) uint64 { return uint64(key.(int)*7919) % 1009 }
+
+	t1, t2 := New(1, cmpInt), New(2, cmpInt)
+	t1.SetPriorityFunc(keyPriority)
+	t2.SetPriorityFunc(keyPriority)
+	for i := 0; i < N; i++ {
+		t1.Insert(i)
+		t2.Insert(N - 1 - i)
+	}
+
+	assert.True(t, t1.check())
+	assert.True(t, t2.check())
+	assert.True(t, t1.TopologicalEqual(t2), "same priorities must produce the same tree")
+
+	// increasing priorities produce a tree degenerated in a list
+	list := New(1, cmpInt)
+	list.SetPriorityFunc(func(key interface{}) uint64 { return uint64(key.(int)) })
+	for i := N - 1; i >= 0; i-- {
+		list.Insert(i)
+	}
+	assert.True(t, list.check())
+	for p := *list.rootPtr; p != nullNodePtr; p = p.rlink {
+		assert.Equal(t, nullNodePtr, p.llink)
+	}
+
+	list.SetPriorityFunc(nil)
+	list.Insert(N)
+	assert.True(t, list.check())
+}
+
+func TestTreap_PriorityFuncSurvivesSplits(t *testing.T) {
+
+	keyPriority := func(key interface{}) uint64 { return uint64(key.(int)*7919) % 1009 }
+	build := func() *Treap {
+		tree := New(1, cmpInt)
+		tree.SetPriorityFunc(keyPriority)
+		for i := 0; i < 100; i++ {
+			tree.Insert(i)
+		}
+		return tree
+	}
+
+	tree := build()
+	extracted := tree.ExtractRange(2, 4)
+	ts, tg := build().SplitByPosition(49)
+	for _, derived := range []*Treap{tree, extracted, ts, tg} {
+		derived.Insert(1000)
+		assert.Equal(t, keyPriority(1000), __search(*derived.rootPtr, 1000, derived.cmp).priority)
+	}
+
+	// after the reinsertion of the extracted keys, tree is the same as if nothing happened
+	tree.Remove(1000)
+	for i := 2; i <= 4; i++ {
+		tree.Insert(i)
+	}
+	assert.True(t, tree.TopologicalEqual(build()))
+}
+
+func TestTreap_CopyIndependentGenerator(t *testing.T) {
+
+	t1 := New(1, cmpInt, 1, 2, 3, 4, 5)
+	t2 := t1.Copy()
+
+	assert.True(t, t1.TopologicalEqual(t2))
+	assert.NotEqual(t, t1.seed, t2.seed)
+
+	same := 0
+	for i := 0; i < 10; i++ {
+		if t1.randGenerator.Uint64() == t2.randGenerator.Uint64() {
+			same++
+		}
+	}
+	assert.Less(t, same, 10, "priority sequences of original and copy should diverge")
+}
+
+func TestTreap_PositionalErrors(t *testing.T) {
+
+	tree := New(1, cmpInt, 0, 1, 2, 3, 4)
+
+	key, err := tree.ChooseE(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, key)
+
+	_, err = tree.ChooseE(5)
+	assert.Equal(t, &ErrPositionOutOfRange{Pos: 5, Size: 5}, err)
+	_, err = tree.ChooseE(-1)
+	assert.Equal(t, &ErrPositionOutOfRange{Pos: -1, Size: 5}, err)
+
+	_, err = tree.RemoveByPosE(7)
+	var posErr *ErrPositionOutOfRange
+	assert.ErrorAs(t, err, &posErr)
+	assert.Equal(t, 7, posErr.Pos)
+	assert.Equal(t, 5, posErr.Size)
+	assert.Equal(t, 5, tree.Size())
+
+	key, err = tree.RemoveByPosE(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, key)
+	assert.True(t, tree.check())
+
+	ts, tg, err := tree.SplitByPositionE(4)
+	assert.Nil(t, ts)
+	assert.Nil(t, tg)
+	assert.Equal(t, &ErrPositionOutOfRange{Pos: 4, Size: 4}, err)
+	assert.Equal(t, 4, tree.Size())
+
+	ts, tg, err = tree.SplitByPositionE(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, ts.Compare(NewTreap(cmpInt, 1, 2)))
+	assert.Equal(t, 0, tg.Compare(NewTreap(cmpInt, 3, 4)))
+	assert.True(t, tree.IsEmpty())
+}
+
+func TestTreap_RemoveRangeByKey(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+	tree.InsertDup(20)
+	tree.InsertDup(30)
+
+	assert.Equal(t, 13, tree.RemoveRangeByKey(20, 30))
+	assert.True(t, tree.check())
+	assert.Equal(t, N-11, tree.Size())
+	assert.Equal(t, 0, tree.RangeCount(20, 30))
+	assert.True(t, tree.Has(19))
+	assert.True(t, tree.Has(31))
+
+	assert.Equal(t, 0, tree.RemoveRangeByKey(50, 40))
+	assert.Equal(t, 0, tree.RemoveRangeByKey(20, 30))
+	assert.Equal(t, N-11, tree.Size())
+
+	assert.Equal(t, 10, tree.RemoveRangeByKey(-5, 9))
+	assert.True(t, tree.check())
+	assert.Equal(t, 10, tree.Min())
+
+	assert.Equal(t, 10, tree.RemoveRangeByKey(90, 200))
+	assert.True(t, tree.check())
+	assert.Equal(t, 89, tree.Max())
+
+	assert.Equal(t, tree.Size(), tree.RemoveRangeByKey(0, N))
+	assert.True(t, tree.check())
+	assert.True(t, tree.IsEmpty())
+	assert.Equal(t, 0, tree.RemoveRangeByKey(0, N))
+
+	// the removed nodes go back to the pool or to the allocator
+	pooled := NewPooled(2, cmpInt)
+	for i := 0; i < N; i++ {
+		pooled.Insert(i)
+	}
+	assert.Equal(t, 11, pooled.RemoveRangeByKey(20, 30))
+	assert.Equal(t, 11, len(pooled.freeList))
+	assert.Equal(t, 20, pooled.RemoveRangeByKey(-5, 19))
+	assert.Equal(t, 31, len(pooled.freeList))
+	assert.True(t, pooled.check())
+
+	freed := 0
+	allocating := NewWithAllocator(3, cmpInt, func() *Node { return new(Node) },
+		func(*Node) { freed++ }, 1, 2, 3, 4, 5)
+	assert.Equal(t, 3, allocating.RemoveRangeByKey(2, 4))
+	assert.Equal(t, 3, freed)
+	assert.Equal(t, "[1 5]", allocating.String())
+}
+
+func TestTreap_RangeSlice(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(2 * i)
+	}
+	tree.InsertDup(10)
+	c := tree.Copy()
+
+	assert.Equal(t, []interface{}{10, 10, 12, 14, 16, 18, 20}, tree.RangeSlice(10, 20))
+	assert.Equal(t, []interface{}{12, 14, 16, 18}, tree.RangeSlice(11, 19))
+	assert.Equal(t, []interface{}{0, 2}, tree.RangeSlice(-10, 3))
+	assert.Equal(t, []interface{}{196, 198}, tree.RangeSlice(195, 1000))
+	assert.Equal(t, []interface{}{}, tree.RangeSlice(11, 11))
+	assert.Equal(t, []interface{}{}, tree.RangeSlice(20, 10))
+	assert.Equal(t, N+1, len(tree.RangeSlice(0, 2*N)))
+	assert.Equal(t, []interface{}{}, NewTreap(cmpInt).RangeSlice(0, 10))
+
+	assert.True(t, tree.TopologicalEqual(c))
+}
+
+func TestTreap_CountOf(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 1, 2, 2, 3, 3, 3, 5)
+
+	assert.Equal(t, 1, tree.CountOf(1))
+	assert.Equal(t, 2, tree.CountOf(2))
+	assert.Equal(t, 3, tree.CountOf(3))
+	assert.Equal(t, 0, tree.CountOf(4))
+	assert.Equal(t, 1, tree.CountOf(5))
+	assert.Equal(t, 0, tree.CountOf(100))
+	assert.Equal(t, 0, NewTreap(cmpInt).CountOf(1))
+}
+
+func TestTreap_RemoveAll(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 1, 2, 2, 3, 3, 3, 5)
+
+	assert.Equal(t, 3, tree.RemoveAll(3))
+	assert.True(t, tree.check())
+	assert.Equal(t, 4, tree.Size())
+	assert.False(t, tree.Has(3))
+
+	assert.Equal(t, 0, tree.RemoveAll(4))
+	assert.Equal(t, 2, tree.RemoveAll(2))
+	assert.Equal(t, 1, tree.RemoveAll(1))
+	assert.Equal(t, 1, tree.RemoveAll(5))
+	assert.True(t, tree.check())
+	assert.True(t, tree.IsEmpty())
+}
+
+func TestTreap_String(t *testing.T) {
+
+	assert.Equal(t, "[]", NewTreap(cmpInt).String())
+	assert.Equal(t, "[7]", NewTreap(cmpInt, 7).String())
+	assert.Equal(t, "[1 2 2 3]", NewTreap(cmpInt, 3, 2, 1, 2).String())
+}
+
+func TestTreap_ToDOT(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	tree.SetPriorityFunc(func(key interface{}) uint64 {
+		return map[int]uint64{1: 20, 2: 10, 3: 30}[key.(int)]
+	})
+	tree.Insert(1)
+	tree.Insert(2)
+	tree.Insert(3)
+
+	expected := `digraph treap {
+  n0 [label="2\np=10\nc=3"];
+  n0 -> n1;
+  n1 [label="1\np=20\nc=1"];
+  n0 -> n2;
+  n2 [label="3\np=30\nc=1"];
+}
+`
+	assert.Equal(t, expected, tree.ToDOT())
+	assert.Equal(t, "digraph treap {\n}\n", NewTreap(cmpInt).ToDOT())
+}
+
+func TestTreap_Validate(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	insertNRandomItems(tree, 1000)
+	tree.InsertDup(tree.Min())
+	tree.InsertDup(tree.Max())
+	assert.Nil(t, tree.Validate())
+	assert.Nil(t, NewTreap(cmpInt).Validate())
+
+	root := *tree.rootPtr
+
+	// a key deep in the left subtree greater than the root
+	p := root.llink
+	for p.rlink != nullNodePtr {
+		p = p.rlink
+	}
+	key := p.key
+	p.key = root.key.(int) + 1
+	assert.Regexp(t, "BST order violated", tree.Validate())
+	p.key = key
+	assert.Nil(t, tree.Validate())
+
+	root.llink.priority, root.priority = root.priority, root.llink.priority
+	assert.Regexp(t, "heap order violated", tree.Validate())
+	root.llink.priority, root.priority = root.priority, root.llink.priority
+
+	root.rlink.count++
+	assert.Regexp(t, "count violated", tree.Validate())
+	root.rlink.count--
+	assert.Nil(t, tree.Validate())
+}
+
+func TestTreap_HeightAndAverageDepth(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	assert.Equal(t, 0, tree.Height())
+	assert.Equal(t, 0.0, tree.AverageDepth())
+
+	tree.Insert(1)
+	assert.Equal(t, 1, tree.Height())
+	assert.Equal(t, 0.0, tree.AverageDepth())
+
+	// a list of N nodes has height N and average depth (N - 1)/2
+	const N = 100
+	list := New(1, cmpInt)
+	list.SetPriorityFunc(func(key interface{}) uint64 { return uint64(key.(int)) })
+	for i := 0; i < N; i++ {
+		list.Insert(i)
+	}
+	assert.Equal(t, N, list.Height())
+	assert.Equal(t, float64(N-1)/2, list.AverageDepth())
+
+	insertNRandomItems(tree, 10000)
+	assert.Less(t, tree.Height(), 100)
+	assert.Less(t, tree.AverageDepth(), float64(tree.Height()))
+}
+
+func TestTreap_DepthOf(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	tree.SetPriorityFunc(func(key interface{}) uint64 {
+		return map[int]uint64{1: 20, 2: 10, 3: 30, 4: 40}[key.(int)]
+	})
+	tree.Insert(1)
+	tree.Insert(2)
+	tree.Insert(3)
+	tree.Insert(4)
+
+	for key, expected := range map[int]int{2: 0, 1: 1, 3: 1, 4: 2} {
+		depth, ok := tree.DepthOf(key)
+		assert.True(t, ok)
+		assert.Equal(t, expected, depth)
+	}
+
+	_, ok := tree.DepthOf(5)
+	assert.False(t, ok)
+	_, ok = NewTreap(cmpInt).DepthOf(5)
+	assert.False(t, ok)
+}
+
+func TestTreap_PopFrontBack(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 5, 1, 3, 3, 9, 7)
+	c := tree.Copy()
+
+	for !tree.IsEmpty() {
+		assert.Equal(t, c.RemoveByPos(0), tree.PopFront())
+		assert.True(t, tree.check())
+		if !tree.IsEmpty() {
+			assert.Equal(t, c.RemoveByPos(c.Size()-1), tree.PopBack())
+			assert.True(t, tree.check())
+		}
+		assert.Equal(t, c.Size(), tree.Size())
+	}
+
+	assert.Nil(t, tree.PopFront())
+	assert.Nil(t, tree.PopBack())
+}
+
+func TestTreap_Map(t *testing.T) {
+
+	tree := NewTreap(cmpInt, -3, -2, -1, 0, 1, 2, 3)
+	c := tree.Copy()
+	abs := func(key interface{}) interface{} {
+		if i := key.(int); i < 0 {
+			return -i
+		}
+		return key
+	}
+
+	set := tree.Map(abs, cmpInt, false)
+	assert.True(t, set.check())
+	assert.Equal(t, "[0 1 2 3]", set.String())
+
+	multiset := tree.Map(abs, cmpInt, true)
+	assert.True(t, multiset.check())
+	assert.Equal(t, "[0 1 1 2 2 3 3]", multiset.String())
+
+	desc := tree.Map(func(key interface{}) interface{} { return key.(int) * 10 },
+		func(i1, i2 interface{}) bool { return cmpInt(i2, i1) }, false)
+	assert.True(t, desc.check())
+	assert.Equal(t, "[30 20 10 0 -10 -20 -30]", desc.String())
+
+	assert.True(t, tree.TopologicalEqual(c))
+}
+
+func TestTreap_Filter(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 1, 2, 2, 3, 4, 4, 5, 6)
+	c := tree.Copy()
+
+	even := tree.Filter(func(key interface{}) bool { return key.(int)%2 == 0 })
+	assert.True(t, even.check())
+	assert.Equal(t, "[2 2 4 4 6]", even.String())
+
+	assert.True(t, tree.Filter(func(key interface{}) bool { return false }).IsEmpty())
+	assert.True(t, tree.Filter(func(key interface{}) bool { return true }).Equal(tree))
+	assert.True(t, tree.TopologicalEqual(c))
+
+	// the filtered tree keeps the key type and the allocator
+	all := func(key interface{}) bool { return true }
+	typed := NewTyped(0, cmpInt, 1, 2, 3).Filter(all)
+	assert.Panics(t, func() { typed.Insert("a") })
+	allocated := 0
+	alloc := func() *Node {
+		allocated++
+		return new(Node)
+	}
+	allocating := NewWithAllocator(1, cmpInt, alloc, nil, 1, 2, 3)
+	allocated = 0
+	assert.Equal(t, "[1 2 3]", allocating.Filter(all).String())
+	assert.Equal(t, 3, allocated)
+}
+
+func TestTreap_Fold(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 3, 1, 2, 4)
+	concat := func(acc, key interface{}) interface{} { return acc.(string) + fmt.Sprint(key) }
+
+	assert.Equal(t, 10, tree.Fold(0, func(acc, key interface{}) interface{} {
+		return acc.(int) + key.(int)
+	}))
+	assert.Equal(t, "1234", tree.Fold("", concat))
+	assert.Equal(t, "4321", tree.FoldRight("", concat))
+
+	assert.Equal(t, "x", NewTreap(cmpInt).Fold("x", concat))
+	assert.Equal(t, "x", NewTreap(cmpInt).FoldRight("x", concat))
+}
+
+func TestTreap_AnyAll(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 2, 4, 6, 7, 8)
+	visited := 0
+	even := func(key interface{}) bool {
+		visited++
+		return key.(int)%2 == 0
+	}
+
+	assert.False(t, tree.All(even))
+	assert.Equal(t, 4, visited, "All should stop at 7")
+
+	visited = 0
+	assert.True(t, tree.Any(even))
+	assert.Equal(t, 1, visited, "Any should stop at 2")
+
+	assert.False(t, tree.Any(func(key interface{}) bool { return key.(int) > 8 }))
+	assert.True(t, tree.All(func(key interface{}) bool { return key.(int) > 1 }))
+
+	assert.False(t, NewTreap(cmpInt).Any(even))
+	assert.True(t, NewTreap(cmpInt).All(even))
+}
+
+func TestIterator_Seek(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(2 * i)
+	}
+
+	it := NewIterator(tree).Seek(11)
+	assert.True(t, it.HasCurr())
+	assert.Equal(t, 12, it.GetCurr())
+	it.Next()
+	assert.Equal(t, 14, it.GetCurr())
+
+	assert.Equal(t, 20, it.Seek(20).GetCurr())
+	assert.Equal(t, 0, it.Seek(-5).GetCurr())
+	it.Prev()
+	assert.False(t, it.HasCurr())
+
+	assert.False(t, it.Seek(2*N).HasCurr())
+	it.Prev()
+	assert.Equal(t, 2*(N-1), it.GetCurr())
+
+	assert.False(t, NewIterator(NewTreap(cmpInt)).Seek(1).HasCurr())
+}
+
+func TestNewRangeIterator(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+	tree.InsertDup(20)
+
+	keys := make([]interface{}, 0)
+	for it := NewRangeIterator(tree, 10, 20); it.HasCurr(); it.Next() {
+		keys = append(keys, it.GetCurr())
+	}
+	assert.Equal(t, []interface{}{10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 20}, keys)
+	assert.Equal(t, tree.RangeSlice(-10, 5), rangeIteratorKeys(NewRangeIterator(tree, -10, 5)))
+	assert.Equal(t, tree.RangeSlice(95, 200), rangeIteratorKeys(NewRangeIterator(tree, 95, 200)))
+
+	assert.False(t, NewRangeIterator(tree, 20, 10).HasCurr())
+	assert.False(t, NewRangeIterator(tree, 200, 300).HasCurr())
+	assert.False(t, NewRangeIterator(tree, -20, -10).HasCurr())
+	assert.False(t, NewRangeIterator(NewTreap(cmpInt), 0, 10).HasCurr())
+}
+
+func TestNewRangeIterator_LowerBound(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 10; i++ {
+		tree.Insert(i)
+	}
+
+	it := NewRangeIterator(tree, 3, 6)
+	it.Next().Next()
+	assert.Equal(t, 5, it.GetCurr())
+	assert.Equal(t, 3, it.ResetFirst().GetCurr())
+	assert.Equal(t, 3, it.Seek(1).GetCurr())
+	assert.Equal(t, 6, it.ResetLast().GetCurr())
+
+	keys := make([]interface{}, 0)
+	for it.ResetLast(); it.HasCurr(); it.Prev() {
+		keys = append(keys, it.GetCurr())
+	}
+	assert.Equal(t, []interface{}{6, 5, 4, 3}, keys)
+	assert.Equal(t, 2, it.GetPos())
+	assert.Panics(t, func() { it.Prev() })
+	assert.Equal(t, 3, it.Next().GetCurr())
+
+	empty := NewRangeIterator(tree, 7, 4)
+	assert.False(t, empty.ResetFirst().HasCurr())
+	assert.Panics(t, func() { empty.ResetLast() })
+}
+
+func rangeIteratorKeys(it *Iterator) []interface{} {
+	keys := make([]interface{}, 0)
+	for ; it.HasCurr(); it.Next() {
+		keys = append(keys, it.GetCurr())
+	}
+	return keys
+}
+
+func TestIterator_FluentChaining(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 1, 2, 3)
+	it := NewReverseIterator(tree)
+
+	assert.Equal(t, 2, it.ResetFirst().Next().GetCurr())
+	assert.Equal(t, 2, it.ResetLast().Prev().GetCurr())
+	assert.Equal(t, 3, it.Next().GetCurr())
+}
+
+func TestIterator_Clone(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 1, 2, 3, 4, 5)
+	it := NewIterator(tree).Next()
+	saved := it.Clone()
+
+	it.Next().Next()
+	assert.Equal(t, 4, it.GetCurr())
+	assert.Equal(t, 2, saved.GetCurr())
+
+	saved.Prev()
+	assert.Equal(t, 1, saved.GetCurr())
+	assert.Equal(t, 4, it.GetCurr())
+
+	end := NewIterator(tree).Seek(10)
+	assert.False(t, end.Clone().HasCurr())
+	assert.Equal(t, 5, end.Clone().Prev().GetCurr())
+}
+
+func TestTreap_TraverseReverse(t *testing.T) {
+
+	tree := New(3, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	expected := N - 1
+	assert.True(t, tree.TraverseReverse(func(key interface{}) bool {
+		assert.Equal(t, expected, key)
+		expected--
+		return true
+	}))
+	assert.Equal(t, -1, expected)
+
+	top := make([]interface{}, 0)
+	assert.False(t, tree.TraverseReverse(func(key interface{}) bool {
+		top = append(top, key)
+		return len(top) < 3
+	}))
+	assert.Equal(t, []interface{}{N - 1, N - 2, N - 3}, top)
+
+	assert.True(t, NewTreap(cmpInt).TraverseReverse(func(key interface{}) bool { return false }))
+}
+
+func TestTreap_TraverseRange(t *testing.T) {
+
+	tree := New(3, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	acu := 0
+	assert.True(t, tree.TraverseRange(10, 19, func(key interface{}) bool {
+		acu += key.(int)
+		return true
+	}))
+	assert.Equal(t, 145, acu)
+
+	visited := 0
+	assert.False(t, tree.TraverseRange(50, 200, func(key interface{}) bool {
+		visited++
+		return key.(int) < 52
+	}))
+	assert.Equal(t, 3, visited)
+
+	assert.True(t, tree.TraverseRange(20, 10, func(key interface{}) bool { return false }))
+	assert.True(t, tree.TraverseRange(N, 2*N, func(key interface{}) bool { return false }))
+}
+
+func TestIterator_SuccessorConsistency(t *testing.T) {
+
+	tree := New(7, cmpInt)
+	const N = 1000
+	for i := 0; i < N; i++ {
+		tree.InsertDup(rand.Intn(N / 2))
+	}
+
+	// mix forward advances with moves that discard the successor stack
+	it := NewIterator(tree)
+	for step := 0; step < 20*N; step++ {
+		switch op := rand.Intn(10); {
+		case op < 6 && it.GetPos() < N:
+			it.Next()
+		case op < 8 && it.GetPos() > -1:
+			it.Prev()
+		case op == 8:
+			it.Seek(rand.Intn(N / 2))
+		default:
+			it = it.Clone()
+		}
+		if it.HasCurr() {
+			assert.Equal(t, tree.Choose(it.GetPos()), it.GetCurr())
+		}
+	}
+}
+
+func TestIterator_PredecessorConsistency(t *testing.T) {
+
+	tree := New(11, cmpInt)
+	const N = 1000
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+	tree.ReverseRange(100, 600)
+	tree.ReverseRange(300, 900)
+
+	pos := N - 1
+	for it := NewReverseIterator(tree); it.HasCurr(); it.Prev() {
+		assert.Equal(t, pos, it.GetPos())
+		assert.Equal(t, tree.Choose(pos), it.GetCurr())
+		pos--
+	}
+	assert.Equal(t, -1, pos)
+
+	// mix backward advances with moves that discard the predecessor stack
+	it := NewReverseIterator(tree)
+	for step := 0; step < 20*N; step++ {
+		switch op := rand.Intn(10); {
+		case op < 6 && it.GetPos() > -1:
+			it.Prev()
+		case op < 8 && it.GetPos() < N:
+			it.Next()
+		case op == 8:
+			it.ResetLast()
+		default:
+			it = it.Clone()
+		}
+		if it.HasCurr() {
+			assert.Equal(t, tree.Choose(it.GetPos()), it.GetCurr())
+		}
+	}
+}
+
+func TestIterator_GetPos(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 10, 20, 30)
+
+	it := NewIterator(tree)
+	for i := 0; it.HasCurr(); i, it = i+1, it.Next() {
+		assert.Equal(t, i, it.GetPos())
+		_, pos := tree.RankInOrder(it.GetCurr())
+		assert.Equal(t, pos, it.GetPos())
+	}
+	assert.Equal(t, 3, it.GetPos())
+
+	it.ResetFirst().Prev()
+	assert.Equal(t, -1, it.GetPos())
+	assert.Equal(t, 1, it.Seek(15).GetPos())
+}
+
+func TestTreap_SplitThreeWay(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 100
+	for i := 0; i < N; i++ {
+		tree.InsertDup(i % 10)
+	}
+
+	ts, eq, tg := tree.SplitThreeWay(4)
+
+	assert.True(t, tree.IsEmpty())
+	assert.True(t, ts.check())
+	assert.True(t, eq.check())
+	assert.True(t, tg.check())
+	assert.Equal(t, 40, ts.Size())
+	assert.Equal(t, 10, eq.Size())
+	assert.Equal(t, 50, tg.Size())
+	assert.Equal(t, 3, ts.Max())
+	assert.Equal(t, 4, eq.Min())
+	assert.Equal(t, 4, eq.Max())
+	assert.Equal(t, 5, tg.Min())
+
+	ts, eq, tg = tg.SplitThreeWay(20)
+	assert.Equal(t, 50, ts.Size())
+	assert.True(t, eq.IsEmpty())
+	assert.True(t, tg.IsEmpty())
+
+	ts, eq, tg = NewTreap(cmpInt).SplitThreeWay(1)
+	assert.True(t, ts.IsEmpty() && eq.IsEmpty() && tg.IsEmpty())
+}
+
+func TestMergeDupAndUnion(t *testing.T) {
+
+	const K = 7
+	const N = 100
+	shards := make([]*Treap, 0, K)
+	for k := 0; k < K; k++ {
+		shard := New(int64(k), cmpInt)
+		for i := k; i < N; i += k + 1 {
+			shard.Insert(i)
+		}
+		shards = append(shards, shard)
+	}
+	copies := make([]*Treap, 0, K)
+	total := 0
+	for _, shard := range shards {
+		copies = append(copies, shard.Copy())
+		total += shard.Size()
+	}
+
+	merged := MergeDup(shards...)
+	assert.True(t, merged.check())
+	assert.Equal(t, total, merged.Size())
+	for _, shard := range shards {
+		assert.True(t, shard.IsEmpty())
+	}
+
+	union := MergeUnion(copies...)
+	assert.True(t, union.check())
+	assert.Equal(t, N, union.Size())
+	assert.Equal(t, 0, union.Min())
+	assert.Equal(t, N-1, union.Max())
+	for _, c := range copies {
+		assert.True(t, c.IsEmpty())
+	}
+
+	single := NewTreap(cmpInt, 1, 2, 3)
+	assert.Equal(t, "[1 2 3]", MergeUnion(single).String())
+	assert.True(t, single.IsEmpty())
+
+	assert.Panics(t, func() { MergeDup() })
+}
+
+type counter struct {
+	word  string
+	count int
+}
+
+func cmpCounter(i1, i2 interface{}) bool {
+	return i1.(counter).word < i2.(counter).word
+}
+
+func TestTreap_Upsert(t *testing.T) {
+
+	tree := NewTreap(cmpCounter)
+	increment := func(existing interface{}) interface{} {
+		c := existing.(counter)
+		c.count++
+		return c
+	}
+
+	for _, word := range []string{"b", "a", "b", "c", "b", "a"} {
+		tree.Upsert(counter{word, 1}, increment)
+	}
+
+	assert.True(t, tree.check())
+	assert.Equal(t, 3, tree.Size())
+	assert.Equal(t, counter{"a", 2}, tree.Search(counter{word: "a"}))
+	assert.Equal(t, counter{"b", 3}, tree.Search(counter{word: "b"}))
+	assert.Equal(t, counter{"c", 1}, tree.Search(counter{word: "c"}))
+	assert.Equal(t, counter{"d", 1}, tree.Upsert(counter{"d", 1}, increment))
+	assert.Equal(t, counter{"d", 2}, tree.Upsert(counter{"d", 1}, increment))
+
+	assert.Panics(t, func() {
+		tree.Upsert(counter{"a", 1}, func(existing interface{}) interface{} {
+			return counter{"z", 1}
+		})
+	})
+	assert.Equal(t, counter{"a", 2}, tree.Search(counter{word: "a"}))
+}
+
+func TestTreap_UpdateKey(t *testing.T) {
+
+	tree := NewTreap(cmpCounter, counter{"a", 1}, counter{"b", 2}, counter{"c", 3})
+
+	assert.True(t, tree.UpdateKey(counter{word: "a"}, counter{"d", 1}))
+	assert.True(t, tree.check())
+	assert.False(t, tree.Has(counter{word: "a"}))
+	assert.Equal(t, counter{"d", 1}, tree.Max())
+
+	assert.True(t, tree.UpdateKey(counter{word: "b"}, counter{"b", 5}))
+	assert.Equal(t, counter{"b", 5}, tree.Min())
+
+	assert.False(t, tree.UpdateKey(counter{word: "x"}, counter{"y", 1}), "old key is not in tree")
+	assert.False(t, tree.UpdateKey(counter{word: "b"}, counter{"c", 1}), "new key is already in tree")
+	assert.Equal(t, 3, tree.Size())
+	assert.Equal(t, counter{"b", 5}, tree.Search(counter{word: "b"}))
+	assert.Equal(t, counter{"c", 3}, tree.Search(counter{word: "c"}))
+	assert.True(t, tree.check())
+}
+
+func TestTreap_InsertManyRemoveMany(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 1, 2, 3)
+
+	assert.Equal(t, 2, tree.InsertMany(3, 4, 5, 4))
+	assert.True(t, tree.check())
+	assert.Equal(t, "[1 2 3 4 5]", tree.String())
+	assert.Equal(t, 0, tree.InsertMany())
+
+	assert.Equal(t, 3, tree.RemoveMany(1, 5, 7, 3, 3))
+	assert.True(t, tree.check())
+	assert.Equal(t, "[2 4]", tree.String())
+	assert.Equal(t, 0, tree.RemoveMany(10))
+}
+
+func TestTreap_SearchOrInsertWith(t *testing.T) {
+
+	tree := NewTreap(cmpCounter, counter{"a", 1})
+	calls := 0
+	create := func(word string) func() interface{} {
+		return func() interface{} {
+			calls++
+			return counter{word, 10}
+		}
+	}
+
+	ok, key := tree.SearchOrInsertWith(counter{word: "a"}, create("a"))
+	assert.False(t, ok)
+	assert.Equal(t, counter{"a", 1}, key)
+	assert.Equal(t, 0, calls)
+
+	ok, key = tree.SearchOrInsertWith(counter{word: "b"}, create("b"))
+	assert.True(t, ok)
+	assert.Equal(t, counter{"b", 10}, key)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, counter{"b", 10}, tree.Search(counter{word: "b"}))
+	assert.True(t, tree.check())
+
+	assert.Panics(t, func() { tree.SearchOrInsertWith(counter{word: "c"}, create("d")) })
+	assert.Equal(t, 2, tree.Size())
+}
+
+// Apply a random mutation to tree and to reference, which must contain the same keys
+func randomMutation(tree, reference *Treap, n int) {
+
+	key := rand.Intn(n)
+	switch rand.Intn(9) {
+	case 0:
+		tree.Insert(key)
+		reference.Insert(key)
+	case 1:
+		tree.InsertDup(key)
+		reference.InsertDup(key)
+	case 2:
+		tree.Remove(key)
+		reference.Remove(key)
+	case 3:
+		tree.ExtractMin()
+		reference.ExtractMin()
+	case 4:
+		tree.ExtractMax()
+		reference.ExtractMax()
+	case 5:
+		if tree.Size() > 0 {
+			pos := rand.Intn(tree.Size())
+			tree.RemoveByPos(pos)
+			reference.RemoveByPos(pos)
+		}
+	case 6:
+		tree.RemoveRangeByKey(key, key+n/20)
+		reference.RemoveRangeByKey(key, key+n/20)
+	case 7:
+		for _, t := range []*Treap{tree, reference} {
+			ts, tg := t.SplitByKey(key)
+			ts.JoinDup(tg)
+			ts.UpdateKey(key, key)
+			t.Swap(ts)
+		}
+	default:
+		increment := func(existing interface{}) interface{} { return existing }
+		tree.Upsert(key, increment)
+		reference.Upsert(key, increment)
+	}
+}
+
+func TestTreap_Snapshot(t *testing.T) {
+
+	const N = 500
+	tree := New(1, cmpInt)
+	insertNRandomItems(tree, N)
+
+	trees := []*Treap{tree}
+	references := []*Treap{tree.Copy()}
+	for step := 0; step < 5000; step++ {
+		if rand.Intn(100) == 0 { // snapshot of a random tree
+			i := rand.Intn(len(trees))
+			trees = append(trees, trees[i].Snapshot())
+			references = append(references, references[i].Copy())
+		}
+
+		i := rand.Intn(len(trees))
+		randomMutation(trees[i], references[i], N)
+
+		if step%50 == 0 {
+			for j := range trees {
+				assert.True(t, trees[j].check())
+				assert.True(t, trees[j].Equal(references[j]))
+			}
+		}
+	}
+
+	for j := range trees {
+		assert.True(t, trees[j].check())
+		assert.True(t, trees[j].Equal(references[j]))
+	}
+}
+
+// Helper that collects the nodes of tree root
+func collectNodes(root *Node, nodes map[*Node]bool) {
+	if root == nullNodePtr {
+		return
+	}
+	nodes[root] = true
+	collectNodes(root.llink, nodes)
+	collectNodes(root.rlink, nodes)
+}
+
+func TestTreap_SnapshotCopiesOnlyThePath(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	const N = 10000
+	for i := 0; i < N; i++ {
+		tree.Insert(2 * i)
+	}
+
+	snapshot := tree.Snapshot()
+	tree.Insert(N + 1)
+	tree.Remove(N / 2)
+
+	original := make(map[*Node]bool)
+	collectNodes(*snapshot.rootPtr, original)
+	copied := 0
+	current := make(map[*Node]bool)
+	collectNodes(*tree.rootPtr, current)
+	for p := range current {
+		if !original[p] {
+			copied++
+		}
+	}
+
+	assert.Less(t, copied, 2*tree.Height()+2)
+	assert.Equal(t, N, snapshot.Size())
+	assert.True(t, snapshot.check())
+	assert.True(t, snapshot.Has(N/2))
+	assert.False(t, snapshot.Has(N+1))
+}
+
+func TestTreap_ToSliceAndAsSortable(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 4, 2, 3, 1, 3)
+	assert.Equal(t, []interface{}{1, 2, 3, 3, 4}, tree.ToSlice())
+	assert.Equal(t, []interface{}{}, NewTreap(cmpInt).ToSlice())
+
+	sortable := tree.AsSortable()
+	assert.Equal(t, 5, sortable.Len())
+	assert.True(t, sort.IsSorted(sortable))
+	assert.True(t, sortable.Less(0, 4))
+	assert.False(t, sortable.Less(2, 3))
+
+	sort.Sort(sort.Reverse(sortable))
+	assert.Equal(t, []interface{}{4, 3, 3, 2, 1}, sortable.(*sortableKeys).keys)
+	assert.Equal(t, "[1 2 3 3 4]", tree.String())
+	assert.True(t, tree.check())
+}
+
+func TestTreap_Nearest(t *testing.T) {
+
+	tree := NewTreap(cmpInt, 10, 20, 30, 45)
+	calls := 0
+	dist := func(a, b interface{}) float64 {
+		calls++
+		return math.Abs(float64(a.(int) - b.(int)))
+	}
+
+	for key, expected := range map[int]int{-5: 10, 10: 10, 14: 10, 15: 10, 16: 20, 37: 30, 38: 45, 100: 45} {
+		calls = 0
+		nearest, ok := tree.Nearest(key, dist)
+		assert.True(t, ok)
+		assert.Equal(t, expected, nearest, "nearest to %d", key)
+		assert.LessOrEqual(t, calls, 2)
+	}
+
+	_, ok := NewTreap(cmpInt).Nearest(1, dist)
+	assert.False(t, ok)
+}
+
+func TestTreap_PooledReusesNodes(t *testing.T) {
+
+	const N = 1000
+	tree := NewPooled(1, cmpInt)
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	before := make(map[*Node]bool)
+	collectNodes(*tree.rootPtr, before)
+	tree.Clear()
+	assert.Equal(t, N, len(tree.freeList))
+	for _, p := range tree.freeList {
+		assert.Nil(t, p.key)
+		assert.Equal(t, uint64(0), p.priority)
+		assert.Equal(t, 1, p.count)
+		assert.Equal(t, nullNodePtr, p.llink)
+		assert.Equal(t, nullNodePtr, p.rlink)
+	}
+
+	for i := 0; i < N; i++ {
+		tree.Insert(N - i)
+	}
+	after := make(map[*Node]bool)
+	collectNodes(*tree.rootPtr, after)
+	assert.Equal(t, before, after)
+	assert.Equal(t, 0, len(tree.freeList))
+	assert.True(t, tree.check())
+
+	assert.Nil(t, tree.Insert(N)) // failed insertion gives back the node
+	assert.Equal(t, 1, len(tree.freeList))
+	assert.Equal(t, N, tree.Remove(N))
+	assert.Equal(t, 1, tree.ExtractMin())
+	assert.Equal(t, N-1, tree.ExtractMax())
+	assert.Equal(t, 4, len(tree.freeList))
+	assert.Equal(t, N-3, tree.Size())
+	assert.True(t, tree.check())
+}
+
+func TestTreap_PooledWithSnapshots(t *testing.T) {
+
+	const N = 500
+	tree := NewPooled(1, cmpInt)
+	insertNRandomItems(tree, N)
+
+	trees := []*Treap{tree}
+	references := []*Treap{tree.Copy()}
+	for step := 0; step < 5000; step++ {
+		i := rand.Intn(len(trees))
+		switch rand.Intn(100) {
+		case 0: // snapshot of a random tree
+			trees = append(trees, trees[i].Snapshot())
+			references = append(references, references[i].Copy())
+		case 1:
+			trees[i].Clear()
+			references[i].Clear()
+		default:
+			randomMutation(trees[i], references[i], N)
+		}
+
+		if step%50 == 0 {
+			for j := range trees {
+				assert.True(t, trees[j].check())
+				assert.True(t, trees[j].Equal(references[j]))
+			}
+		}
+	}
+
+	for j := range trees {
+		assert.True(t, trees[j].check())
+		assert.True(t, trees[j].Equal(references[j]))
+	}
+}
+
+func benchmarkFillAndClear(b *testing.B, tree *Treap) {
+
+	const N = 1000
+	keys := make([]interface{}, N)
+	for i := range keys {
+		keys[i] = rand.Intn(10 * N)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, key := range keys {
+			tree.InsertDup(key)
+		}
+		tree.Clear()
+	}
+}
+
+func BenchmarkTreap_FillAndClear(b *testing.B) {
+	benchmarkFillAndClear(b, New(1, cmpInt))
+}
+
+func BenchmarkTreap_PooledFillAndClear(b *testing.B) {
+	benchmarkFillAndClear(b, NewPooled(1, cmpInt))
+}
+
+func TestTreap_ApproxMemoryBytes(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	assert.Equal(t, 0, tree.NodeCount())
+	empty := tree.ApproxMemoryBytes()
+	assert.Greater(t, empty, 0)
+
+	insertNRandomItems(tree, 100)
+	assert.Equal(t, tree.Size(), tree.NodeCount())
+	perNode := (tree.ApproxMemoryBytes() - empty) / tree.Size()
+	assert.Equal(t, int(unsafe.Sizeof(Node{})), perNode)
+
+	pooled := NewPooled(1, cmpInt, 1, 2, 3)
+	pooled.Remove(2)
+	assert.Equal(t, 2, pooled.Size())
+	assert.Equal(t, 3, pooled.NodeCount())
+}
+
+func TestTreap_NewWithCmp(t *testing.T) {
+
+	calls := 0
+	cmp := func(i1, i2 interface{}) int {
+		calls++
+		return i1.(int) - i2.(int)
+	}
+
+	const N = 1000
+	tree := NewWithCmp(7, cmp)
+	reference := New(7, cmpInt)
+	for i := 0; i < N; i++ {
+		key := rand.Intn(2 * N)
+		assert.Equal(t, reference.Insert(key), tree.Insert(key))
+	}
+	assert.True(t, tree.check())
+	assert.True(t, tree.TopologicalEqual(reference))
+
+	for key := 0; key < 2*N; key++ {
+		depth, found := tree.DepthOf(key)
+		calls = 0
+		assert.Equal(t, reference.Search(key), tree.Search(key))
+		if found { // exactly one comparison per visited node
+			assert.Equal(t, depth+1, calls)
+		} else {
+			assert.LessOrEqual(t, calls, tree.Height())
+		}
+
+		calls = 0
+		ok, pos := tree.RankInOrder(key)
+		refOk, refPos := reference.RankInOrder(key)
+		assert.Equal(t, refOk, ok)
+		assert.Equal(t, refPos, pos)
+		assert.LessOrEqual(t, calls, tree.Height())
+	}
+
+	for key := 0; key < 2*N; key += 3 {
+		calls = 0
+		assert.Equal(t, reference.Remove(key), tree.Remove(key))
+		assert.LessOrEqual(t, calls, tree.Height()+1)
+	}
+	assert.True(t, tree.check())
+	assert.True(t, tree.Equal(reference))
+
+	ts, tg := tree.SplitByKey(N)
+	for _, split := range []*Treap{ts, tg} { // the split trees keep the three-way comparison
+		depth, _ := split.DepthOf(split.Max())
+		calls = 0
+		split.Search(split.Max())
+		assert.Equal(t, depth+1, calls)
+	}
+
+	dup := NewTreapWithCmp(cmp, 3, 1, 2, 1)
+	assert.Equal(t, "[1 1 2 3]", dup.String())
+}
+
+func TestTreap_SearchOrInsertComparesOncePerNode(t *testing.T) {
+
+	calls := 0
+	cmp := func(i1, i2 interface{}) int {
+		calls++
+		return i1.(int) - i2.(int)
+	}
+
+	const N = 1000
+	tree := NewWithCmp(3, cmp)
+	for i := 0; i < N; i++ {
+		key := rand.Intn(2 * N)
+		depth, found := tree.DepthOf(key)
+		height := tree.Height()
+		calls = 0
+		inserted, _ := tree.SearchOrInsert(key)
+		assert.Equal(t, !found, inserted)
+		if found {
+			assert.Equal(t, depth+1, calls)
+		} else {
+			assert.LessOrEqual(t, calls, height)
+		}
+	}
+	assert.True(t, tree.check())
+}
+
+func TestTreap_SearchOrInsertCallsLessOncePerNode(t *testing.T) {
+
+	calls := 0
+	less := func(i1, i2 interface{}) bool {
+		calls++
+		return i1.(int) < i2.(int)
+	}
+
+	const N = 1000
+	tree := New(3, less)
+	for i := 0; i < N; i++ {
+		key := rand.Intn(2 * N)
+		found := tree.Has(key)
+		height := tree.Height()
+		calls = 0
+		inserted, res := tree.SearchOrInsert(key)
+		assert.Equal(t, !found, inserted)
+		assert.Equal(t, key, res)
+		assert.LessOrEqual(t, calls, height+1)
+	}
+	assert.True(t, tree.check())
+
+	calls = 0
+	assert.Equal(t, 7, tree.Upsert(7, func(existing interface{}) interface{} { return existing }))
+	assert.LessOrEqual(t, calls, tree.Height()+2)
+	assert.True(t, tree.check())
+}
+
+func benchmarkSearchOrInsert(b *testing.B, tree *Treap, calls *int) {
+
+	const N = 10000
+	keys := make([]interface{}, N)
+	for i := range keys {
+		keys[i] = rand.Intn(2 * N)
+	}
+
+	*calls = 0
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		tree.SearchOrInsert(keys[n%N])
+	}
+	b.ReportMetric(float64(*calls)/float64(b.N), "cmps/op")
+}
+
+func BenchmarkTreap_SearchOrInsertLess(b *testing.B) {
+	calls := 0
+	less := func(i1, i2 interface{}) bool {
+		calls++
+		return i1.(int) < i2.(int)
+	}
+	benchmarkSearchOrInsert(b, New(1, less), &calls)
+}
+
+// Baseline of BenchmarkTreap_SearchOrInsertLess: the same less wrapped in a three-way comparison,
+// which spends two calls on every node where the search goes to the right
+func BenchmarkTreap_SearchOrInsertLessAsCmp(b *testing.B) {
+	calls := 0
+	less := func(i1, i2 interface{}) bool {
+		calls++
+		return i1.(int) < i2.(int)
+	}
+	benchmarkSearchOrInsert(b, NewWithCmp(1, __cmpFromLess(less)), &calls)
+}
+
+func BenchmarkTreap_SearchOrInsertCmp(b *testing.B) {
+	calls := 0
+	cmp := func(i1, i2 interface{}) int {
+		calls++
+		return i1.(int) - i2.(int)
+	}
+	benchmarkSearchOrInsert(b, NewWithCmp(1, cmp), &calls)
+}
+
+func TestReverse(t *testing.T) {
+
+	tree := New(1, Reverse(cmpInt), 3, 1, 4, 1, 5, 9, 2, 6)
+	assert.True(t, tree.check())
+	assert.Equal(t, 9, tree.Min())
+	assert.Equal(t, 1, tree.Max())
+	assert.Equal(t, 9, tree.Choose(0))
+	assert.Equal(t, 1, tree.Choose(tree.Size()-1))
+	assert.Equal(t, "[9 6 5 4 3 2 1 1]", tree.String())
+
+	reverse := Reverse(cmpInt)
+	assert.True(t, reverse(2, 1))
+	assert.False(t, reverse(1, 2))
+	assert.False(t, reverse(1, 1)) // equal keys stay equal
+}
+
+func TestTreap_ReverseRange(t *testing.T) {
+
+	const N = 300
+	tree := New(1, cmpInt)
+	reference := make([]interface{}, N)
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+		reference[i] = i
+	}
+	snapshot := tree.Snapshot()
+
+	for step := 0; step < 500; step++ {
+		n := len(reference)
+		switch rand.Intn(10) {
+		case 0:
+			pos := rand.Intn(n)
+			assert.Equal(t, reference[pos], tree.RemoveByPos(pos))
+			reference = append(reference[:pos], reference[pos+1:]...)
+		case 1:
+			assert.Equal(t, reference[0], tree.PopFront())
+			reference = reference[1:]
+		case 2:
+			assert.Equal(t, reference[n-1], tree.PopBack())
+			reference = reference[:n-1]
+		default:
+			b := rand.Intn(n)
+			e := b + rand.Intn(n-b)
+			tree.ReverseRange(b, e)
+			for i, j := b, e; i < j; i, j = i+1, j-1 {
+				reference[i], reference[j] = reference[j], reference[i]
+			}
+		}
+
+		if step%50 == 0 {
+			assert.NoError(t, __validateHeap(*tree.rootPtr))
+			assert.NoError(t, __validateCount(*tree.rootPtr))
+			assert.Equal(t, reference, tree.ToSlice())
+			for i := 0; i < len(reference); i += 11 {
+				assert.Equal(t, reference[i], tree.Choose(i))
+			}
+		}
+	}
+
+	assert.Equal(t, reference, tree.ToSlice())
+	it := NewReverseIterator(tree)
+	for i := len(reference) - 1; i >= 0; i-- {
+		assert.Equal(t, reference[i], it.GetCurr())
+		it.Prev()
+	}
+	it.ResetFirst()
+	for i := 0; i < len(reference)/2; i++ {
+		it.Next()
+	}
+	assert.Equal(t, reference[len(reference)/2], it.Clone().GetCurr())
+
+	ts, tg := tree.SplitByPosition(len(reference) / 2)
+	assert.Equal(t, reference[:len(reference)/2+1], ts.ToSlice())
+	assert.Equal(t, reference[len(reference)/2+1:], tg.ToSlice())
+
+	assert.True(t, snapshot.check(), "the snapshot is not affected")
+	assert.Equal(t, N, snapshot.Size())
+	assert.Equal(t, "[1 0 2]", func() string {
+		small := New(1, cmpInt, 0, 1, 2)
+		small.ReverseRange(0, 1)
+		return small.String()
+	}())
+	assert.Panics(t, func() { New(1, cmpInt, 1, 2).ReverseRange(1, 2) })
+}
+
+func TestTreap_SliceRange(t *testing.T) {
+
+	const N = 500
+	tree := New(1, cmpInt)
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	for i := 0; i < 200; i++ {
+		b := rand.Intn(N)
+		e := b + rand.Intn(N-b)
+		slice := tree.SliceRange(b, e)
+		assert.True(t, slice.check())
+		assert.Equal(t, e-b+1, slice.Size())
+		assert.Equal(t, b, slice.Min())
+		assert.Equal(t, e, slice.Max())
+	}
+	assert.Equal(t, N, tree.Size(), "the source is not modified")
+	assert.True(t, tree.check())
+
+	slice := tree.SliceRange(10, 20)
+	slice.Insert(1000)
+	assert.False(t, tree.Has(1000), "the copy is independent")
+	assert.Panics(t, func() { tree.SliceRange(5, N) })
+	assert.Panics(t, func() { tree.SliceRange(5, 4) })
+
+	tree.ReverseRange(100, 199) // positions are read through pending reversals
+	slice = tree.SliceRange(150, 250)
+	expected := tree.ToSlice()[150:251]
+	assert.Equal(t, expected, slice.ToSlice())
+	assert.NoError(t, __validateHeap(*slice.rootPtr))
+	assert.NoError(t, __validateCount(*slice.rootPtr))
+}
+
+func TestTreap_EqualMultisetAndEqualSet(t *testing.T) {
+
+	t1 := New(1, cmpInt, 1, 2, 2, 3)
+	t2 := New(99, cmpInt, 3, 2, 1, 2)
+	t3 := New(5, cmpInt, 1, 2, 3)
+	t4 := New(5, cmpInt, 1, 2, 3, 3)
+
+	assert.True(t, t1.EqualMultiset(t2), "seeds do not matter")
+	assert.False(t, t1.EqualMultiset(t3))
+	assert.False(t, t1.EqualMultiset(t4), "same size, different multiplicities")
+
+	assert.True(t, t1.EqualSet(t2))
+	assert.True(t, t1.EqualSet(t3))
+	assert.True(t, t4.EqualSet(t3))
+	assert.False(t, t1.EqualSet(New(1, cmpInt, 1, 2)))
+	assert.False(t, New(1, cmpInt, 1, 2).EqualSet(t1))
+	assert.True(t, NewTreap(cmpInt).EqualSet(NewTreap(cmpInt)))
+	assert.True(t, NewTreap(cmpInt).EqualMultiset(NewTreap(cmpInt)))
+}
+
+func TestTreap_Checksum(t *testing.T) {
+
+	hashInt := func(key interface{}) uint64 { return uint64(key.(int)) }
+
+	tree := New(1, cmpInt)
+	insertNRandomItems(tree, 1000)
+	other := New(2, cmpInt)
+	for _, key := range tree.ToSlice() {
+		other.InsertDup(key)
+	}
+
+	assert.Equal(t, tree.Checksum(hashInt), tree.Copy().Checksum(hashInt))
+	assert.Equal(t, tree.Checksum(hashInt), other.Checksum(hashInt), "the shape does not matter")
+
+	other.InsertDup(other.Min())
+	assert.NotEqual(t, tree.Checksum(hashInt), other.Checksum(hashInt), "duplicates count")
+	assert.NotEqual(t, New(1, cmpInt, 1, 2).Checksum(hashInt), New(1, cmpInt, 3).Checksum(hashInt))
+	assert.Equal(t, uint64(0), NewTreap(cmpInt).Checksum(hashInt))
+}
+
+func TestTreap_BulkInsert(t *testing.T) {
+
+	const N = 2000
+	tree := New(1, cmpInt)
+	reference := New(2, cmpInt)
+	for i := 0; i < N; i++ {
+		key := rand.Intn(2 * N)
+		tree.Insert(key)
+		reference.Insert(key)
+	}
+	snapshot := tree.Snapshot()
+	before := snapshot.ToSlice()
+
+	items := make([]interface{}, N)
+	for i := range items {
+		items[i] = rand.Intn(3 * N)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].(int) < items[j].(int) })
+
+	expected := 0
+	for _, item := range items {
+		if reference.Insert(item) != nil {
+			expected++
+		}
+	}
+	assert.Equal(t, expected, tree.BulkInsert(items))
+	assert.True(t, tree.check())
+	assert.True(t, tree.Equal(reference))
+	assert.Equal(t, before, snapshot.ToSlice(), "the shared nodes are not modified")
+	assert.True(t, snapshot.check())
+
+	dup := New(3, cmpInt, 1, 3, 3, 5)
+	assert.Equal(t, 4, dup.BulkInsertDup([]interface{}{0, 3, 3, 6}))
+	assert.Equal(t, "[0 1 3 3 3 3 5 6]", dup.String())
+	assert.True(t, dup.check())
+
+	assert.Equal(t, 0, dup.BulkInsert(nil))
+	it := NewIterator(dup)
+	assert.Panics(t, func() { dup.BulkInsert([]interface{}{2, 1}) })
+	assert.Equal(t, "[0 1 3 3 3 3 5 6]", dup.String())
+	assert.Equal(t, 0, it.GetCurr(), "a rejected input does not invalidate the iterators")
+
+	// a key of wrong type is rejected before the nodes of tree are relinked
+	less := func(i1, i2 interface{}) bool { return fmt.Sprint(i1) < fmt.Sprint(i2) }
+	typed := NewTyped(0, less, 1, 3, 5)
+	assert.Panics(t, func() { typed.BulkInsert([]interface{}{2, int64(4)}) })
+	assert.True(t, typed.check())
+	assert.Equal(t, "[1 3 5]", typed.String())
+}
+
+func benchmarkBulk(b *testing.B, bulk bool) {
+
+	const N = 10000
+	tree := New(1, cmpInt)
+	for i := 0; i < N; i++ {
+		tree.Insert(2 * i)
+	}
+	items := make([]interface{}, N)
+	for i := range items {
+		items[i] = 2*i + 1
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		copied := tree.Copy()
+		b.StartTimer()
+		if bulk {
+			copied.BulkInsert(items)
+		} else {
+			copied.InsertMany(items...)
+		}
+	}
+}
+
+func BenchmarkTreap_BulkInsert(b *testing.B) { benchmarkBulk(b, true) }
+
+func BenchmarkTreap_InsertOneByOne(b *testing.B) { benchmarkBulk(b, false) }
+
+func TestTreap_ForEachGroup(t *testing.T) {
+
+	tree := New(1, cmpInt, 3, 1, 3, 2, 3, 1, 5)
+	keys := make([]interface{}, 0)
+	counts := make([]int, 0)
+	assert.True(t, tree.ForEachGroup(func(key interface{}, count int) bool {
+		keys = append(keys, key)
+		counts = append(counts, count)
+		return true
+	}))
+	assert.Equal(t, []interface{}{1, 2, 3, 5}, keys)
+	assert.Equal(t, []int{2, 1, 3, 1}, counts)
+
+	visited := 0
+	assert.False(t, tree.ForEachGroup(func(key interface{}, count int) bool {
+		visited++
+		return key.(int) < 2
+	}))
+	assert.Equal(t, 2, visited)
+
+	assert.True(t, NewTreap(cmpInt).ForEachGroup(func(interface{}, int) bool {
+		panic("no groups expected")
+	}))
+}
+
+func TestTreap_RankLowerBound(t *testing.T) {
+
+	tree := New(1, cmpInt, 10, 20, 20, 30)
+	for key, expected := range map[int]int{5: 0, 10: 0, 15: 1, 20: 1, 25: 3, 30: 3, 35: 4} {
+		assert.Equal(t, expected, tree.RankLowerBound(key), "rank of %d", key)
+	}
+
+	pos := tree.RankLowerBound(25)
+	tree.Insert(25)
+	assert.Equal(t, 25, tree.Choose(pos), "the rank is the insertion position")
+	assert.Equal(t, 0, NewTreap(cmpInt).RankLowerBound(1))
+}
+
+func TestTreap_Partition(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	insertNRandomItems(tree, 1000)
+	tree.InsertDup(tree.Min())
+	keys := tree.ToSlice()
+	snapshot := tree.Snapshot()
+
+	isOdd := func(key interface{}) bool { return key.(int)%2 == 1 }
+	odd, even := tree.Partition(isOdd)
+
+	assert.True(t, tree.IsEmpty())
+	assert.True(t, odd.check())
+	assert.True(t, even.check())
+	assert.Equal(t, len(keys), odd.Size()+even.Size())
+	assert.True(t, odd.All(isOdd))
+	assert.False(t, even.Any(isOdd))
+	for _, key := range keys {
+		assert.True(t, odd.Has(key) || even.Has(key))
+	}
+	assert.Equal(t, keys, snapshot.ToSlice(), "the shared nodes are not moved")
+
+	all, none := NewTreap(cmpInt).Partition(isOdd)
+	assert.True(t, all.IsEmpty())
+	assert.True(t, none.IsEmpty())
+}
+
+func TestTreap_IntersectionCopy(t *testing.T) {
+
+	t1 := New(1, cmpInt)
+	t2 := New(2, cmpInt)
+	for i := 0; i < 1000; i++ {
+		t1.InsertDup(rand.Intn(1500))
+		if i < 300 {
+			t2.Insert(rand.Intn(1500))
+		}
+	}
+	keys1, keys2 := t1.ToSlice(), t2.ToSlice()
+
+	for _, result := range []*Treap{t1.IntersectionCopy(t2), t2.IntersectionCopy(t1)} {
+		assert.True(t, result.check())
+		for i := 0; i < 1500; i++ {
+			assert.Equal(t, t1.Has(i) && t2.Has(i), result.Has(i))
+			assert.LessOrEqual(t, result.CountOf(i), 1)
+		}
+	}
+	assert.Equal(t, keys1, t1.ToSlice(), "the operands are not modified")
+	assert.Equal(t, keys2, t2.ToSlice())
+
+	type pair struct{ key, value int }
+	less := func(i1, i2 interface{}) bool { return i1.(pair).key < i2.(pair).key }
+	small := New(1, less, pair{1, 10})
+	large := New(1, less, pair{0, 0}, pair{1, 20}, pair{2, 0})
+	assert.Equal(t, pair{1, 10}, small.IntersectionCopy(large).Min(), "keys are taken from tree")
+	assert.Equal(t, pair{1, 20}, large.IntersectionCopy(small).Min())
+	assert.True(t, large.IntersectionCopy(NewTreap(less)).IsEmpty())
+}
+
+func TestTreap_Disjoint(t *testing.T) {
+
+	calls := 0
+	less := func(i1, i2 interface{}) bool {
+		calls++
+		return i1.(int) < i2.(int)
+	}
+	evens := New(1, less)
+	odds := New(2, less)
+	for i := 0; i < 1000; i++ {
+		evens.Insert(2 * i)
+		odds.Insert(2*i + 1)
+	}
+
+	assert.True(t, evens.Disjoint(odds))
+	assert.True(t, odds.Disjoint(evens))
+	assert.True(t, evens.Disjoint(NewTreap(less)))
+	assert.Equal(t, 1000, evens.Size())
+
+	small := New(3, less, 0, 1)
+	calls = 0
+	assert.False(t, small.Disjoint(evens))
+	assert.Less(t, calls, 2*evens.Height()+2, "it stops on the first common key")
+	assert.False(t, evens.Disjoint(small))
+}
+
+func TestTreap_IntersectionMerge(t *testing.T) {
+
+	t1 := New(1, cmpInt)
+	t2 := New(2, cmpInt)
+	for i := 0; i < 1000; i++ {
+		t1.InsertDup(rand.Intn(1500))
+		t2.InsertDup(rand.Intn(1500))
+	}
+	keys1, keys2 := t1.ToSlice(), t2.ToSlice()
+
+	result := t1.IntersectionMerge(t2)
+	assert.True(t, result.check())
+	assert.True(t, result.Equal(t1.IntersectionCopy(t2)))
+	assert.True(t, result.Equal(t2.IntersectionMerge(t1)))
+	assert.Equal(t, keys1, t1.ToSlice(), "the operands are not modified")
+	assert.Equal(t, keys2, t2.ToSlice())
+	assert.True(t, t1.IntersectionMerge(NewTreap(cmpInt)).IsEmpty())
+}
+
+func benchmarkIntersection(b *testing.B, intersect func(t1, t2 *Treap)) {
+
+	const N = 10000
+	t1, t2 := New(1, cmpInt), New(2, cmpInt)
+	for i := 0; i < N; i++ {
+		t1.Insert(rand.Intn(2 * N))
+		t2.Insert(rand.Intn(2 * N))
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		c1, c2 := t1.Copy(), t2.Copy()
+		b.StartTimer()
+		intersect(c1, c2)
+	}
+}
+
+func BenchmarkTreap_Intersection(b *testing.B) {
+	benchmarkIntersection(b, func(t1, t2 *Treap) { t1.Intersection(t2) })
+}
+
+func BenchmarkTreap_IntersectionMerge(b *testing.B) {
+	benchmarkIntersection(b, func(t1, t2 *Treap) { t1.IntersectionMerge(t2) })
+}
+
+func TestTreap_Observers(t *testing.T) {
+
+	tree := New(1, cmpInt, 100) // the initial keys are inserted before any observer
+	inserted := make([]interface{}, 0)
+	removed := make([]interface{}, 0)
+	calls := 0
+	tree.OnInsert(func(key interface{}) { inserted = append(inserted, key) })
+	tree.OnInsert(func(key interface{}) { calls++ })
+	tree.OnRemove(func(key interface{}) { removed = append(removed, key) })
+
+	tree.Insert(1)
+	tree.Insert(1) // failed insertion
+	tree.InsertDup(1)
+	tree.Insert(2)
+	tree.Remove(2)
+	tree.Remove(3) // absent key
+	tree.InsertMany(5, 6)
+	tree.RemoveMany(5, 7)
+
+	assert.Equal(t, []interface{}{1, 1, 2, 5, 6}, inserted)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, []interface{}{2, 5}, removed)
+}
+
+func TestNewTyped(t *testing.T) {
+
+	tree := NewTyped(0, cmpInt, 3, 1, 2)
+	assert.Equal(t, "[1 2 3]", tree.String())
+	tree.Insert(4)
+	tree.InsertDup(4)
+
+	assert.PanicsWithValue(t, "Key 5 has type int64, but the tree only accepts keys of type int",
+		func() { tree.Insert(int64(5)) })
+	assert.Panics(t, func() { tree.InsertDup("6") })
+	assert.Panics(t, func() { tree.SearchOrInsert(7.0) })
+	assert.Panics(t, func() { tree.Copy().Insert(uint(8)) }, "copies keep the key type")
+	assert.Equal(t, 5, tree.Size())
+	assert.True(t, tree.check())
+
+	assert.Panics(t, func() { NewTyped(0, cmpInt, 1, "2") })
+}
+
+func TestTreap_ClearAndShrink(t *testing.T) {
+
+	tree := NewPooled(7, cmpInt)
+	insertNRandomItems(tree, 100)
+	tree.Clear()
+	assert.Greater(t, len(tree.freeList), 0)
+
+	insertNRandomItems(tree, 100)
+	tree.ClearAndShrink()
+	assert.True(t, tree.IsEmpty())
+	assert.Nil(t, tree.freeList)
+	assert.Equal(t, 0, tree.NodeCount())
+
+	fresh := NewPooled(7, cmpInt)
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+		fresh.Insert(i)
+	}
+	assert.True(t, tree.TopologicalEqual(fresh), "it behaves as a fresh tree")
+	assert.True(t, tree.pooled)
+}
+
+func TestNewIteratorAt(t *testing.T) {
+
+	const N = 100
+	tree := New(1, cmpInt)
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	for _, pos := range []int{0, 1, 50, N - 1} {
+		it := NewIteratorAt(tree, pos)
+		assert.Equal(t, pos, it.GetPos())
+		for i := pos; i < N; i++ {
+			assert.Equal(t, i, it.GetCurr())
+			it.Next()
+		}
+		assert.False(t, it.HasCurr())
+		assert.Equal(t, pos-1, NewIteratorAt(tree, pos).Prev().GetPos())
+	}
+
+	assert.Panics(t, func() { NewIteratorAt(tree, N) })
+	assert.Panics(t, func() { NewIteratorAt(tree, -1) })
+}
+
+func TestTreap_Page(t *testing.T) {
+
+	const N = 100
+	tree := New(1, cmpInt)
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	for _, c := range []struct{ offset, limit, size int }{
+		{0, 10, 10}, {95, 10, 5}, {50, 0, 0}, {N, 10, 0}, {N + 5, 10, 0}, {0, 2 * N, N},
+	} {
+		page := tree.Page(c.offset, c.limit)
+		assert.Equal(t, c.size, len(page))
+		for i, key := range page {
+			assert.Equal(t, tree.Choose(c.offset+i), key)
+		}
+	}
+
+	assert.Equal(t, 0, len(New(1, cmpInt).Page(0, 10)))
+	assert.Panics(t, func() { tree.Page(-1, 10) })
+	assert.Panics(t, func() { tree.Page(0, -1) })
+}
+
+func TestTreap_SeedAndReSeed(t *testing.T) {
+
+	tree := New(7, cmpInt)
+	assert.Equal(t, int64(7), tree.Seed())
+
+	for i := 0; i < 50; i++ {
+		tree.Insert(i)
+	}
+
+	cpy := tree.Copy()
+	cpy.ReSeed(11)
+	assert.Equal(t, int64(11), cpy.Seed())
+	assert.True(t, cpy.TopologicalEqual(tree))
+
+	tree.Insert(50)
+	cpy.Insert(50)
+	assert.Equal(t, tree.Size(), cpy.Size())
+	assert.True(t, checkAll(*cpy.rootPtr, cmpInt))
+
+	// the priorities drawn after reseeding are those of a fresh generator with the same seed
+	other := New(7, cmpInt)
+	other.ReSeed(11)
+	other.Insert(50)
+	priority := func(tree *Treap) uint64 { return __search(*tree.rootPtr, 50, tree.cmp).priority }
+	assert.Equal(t, priority(other), priority(cpy))
+	assert.NotEqual(t, priority(tree), priority(cpy))
+}
+
+func TestTreap_Rebalance(t *testing.T) {
+
+	const N = 1000
+	tree := New(1, cmpInt)
+	priority := uint64(0)
+	tree.SetPriorityFunc(func(key interface{}) uint64 { priority++; return priority })
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+	assert.Equal(t, N, tree.Height()) // increasing priorities on ascending keys yield a list
+
+	snapshot := tree.Snapshot()
+	tree.SetPriorityFunc(nil)
+	tree.Rebalance()
+
+	assert.True(t, checkAll(*tree.rootPtr, cmpInt))
+	assert.Equal(t, N, tree.Size())
+	assert.True(t, tree.Equal(snapshot))
+	assert.Less(t, tree.Height(), 4*bits.Len(N))
+
+	// the snapshot keeps its topology
+	assert.Equal(t, N, snapshot.Height())
+	assert.True(t, checkAll(*snapshot.rootPtr, cmpInt))
+
+	empty := New(1, cmpInt)
+	empty.Rebalance()
+	assert.True(t, empty.IsEmpty())
+}
+
+func TestTreap_JoinExclusiveE(t *testing.T) {
+
+	ts := New(1, cmpInt, 0, 1, 2, 3)
+	tg := New(2, cmpInt, 3, 4, 5)
+
+	err := ts.JoinExclusiveE(tg)
+	assert.Equal(t, &ErrNotRangeDisjoint{Max: 3, Min: 3}, err)
+	assert.Regexp(t, "not range-disjoint", err.Error())
+	assert.Equal(t, 0, ts.Compare(NewTreap(cmpInt, 0, 1, 2, 3)))
+	assert.Equal(t, 0, tg.Compare(NewTreap(cmpInt, 3, 4, 5)))
+	assert.PanicsWithValue(t, "Trees are not range-disjoint", func() { ts.JoinExclusive(tg) })
+
+	ts.Remove(3)
+	assert.Nil(t, ts.JoinExclusiveE(tg))
+	assert.True(t, ts.check())
+	assert.True(t, tg.IsEmpty())
+	assert.Equal(t, 0, ts.Compare(NewTreap(cmpInt, 0, 1, 2, 3, 4, 5)))
+
+	assert.Nil(t, ts.JoinExclusiveE(New(3, cmpInt)))
+	assert.Equal(t, 6, ts.Size())
+}
+
+func TestTreap_Join(t *testing.T) {
+
+	build := func(seed int64, lo, hi, step int) *Treap {
+		tree := New(seed, cmpInt)
+		for i := lo; i <= hi; i += step {
+			tree.Insert(i)
+		}
+		return tree
+	}
+
+	for _, c := range []struct{ lo1, hi1, step1, lo2, hi2, step2 int }{
+		{0, 99, 1, 100, 199, 1},  // disjoint, ts first
+		{100, 199, 1, 0, 99, 1},  // disjoint, tg first
+		{0, 199, 2, 100, 299, 3}, // partial overlap
+		{0, 999, 1, 300, 400, 1}, // tg inside ts
+		{300, 400, 1, 0, 999, 7}, // ts inside tg
+		{0, 99, 1, 0, 99, 1},     // equal sets
+	} {
+		ts := build(1, c.lo1, c.hi1, c.step1)
+		tg := build(2, c.lo2, c.hi2, c.step2)
+		expected := ts.UnionCopy(tg)
+		snapshot := tg.Snapshot()
+
+		ts.Join(tg)
+		assert.True(t, ts.check())
+		assert.True(t, tg.IsEmpty())
+		assert.True(t, ts.Equal(expected))
+		assert.Equal(t, expected.Size(), ts.Size())
+		assert.Equal(t, 0, snapshot.Compare(build(2, c.lo2, c.hi2, c.step2)))
+	}
+
+	ts := New(1, cmpInt)
+	ts.Join(New(2, cmpInt, 1, 2))
+	assert.Equal(t, 0, ts.Compare(NewTreap(cmpInt, 1, 2)))
+	ts.Join(New(2, cmpInt))
+	assert.Equal(t, 2, ts.Size())
+}
+
+func TestTreap_MinNMaxN(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+
+	assert.Equal(t, []interface{}{0, 1, 2}, tree.MinN(3))
+	assert.Equal(t, []interface{}{99, 98, 97}, tree.MaxN(3))
+	assert.Equal(t, 0, len(tree.MinN(0)))
+	assert.Equal(t, 0, len(tree.MaxN(0)))
+	assert.Equal(t, 100, len(tree.MinN(500)))
+
+	all := tree.MaxN(500)
+	assert.Equal(t, 100, len(all))
+	for i, key := range all {
+		assert.Equal(t, 99-i, key)
+	}
+
+	empty := New(1, cmpInt)
+	assert.Equal(t, 0, len(empty.MinN(5)))
+	assert.Equal(t, 0, len(empty.MaxN(5)))
+	assert.Panics(t, func() { tree.MinN(-1) })
+	assert.Panics(t, func() { tree.MaxN(-1) })
+
+	// the backward walk must honor the pending reversals
+	tree.ReverseRange(50, 99)
+	tree.ReverseRange(20, 70)
+	keys := tree.MaxN(60)
+	for i, key := range keys {
+		assert.Equal(t, tree.Choose(99-i), key)
+	}
+}
+
+func TestTreap_AnyInRange(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 100; i += 10 {
+		tree.Insert(i)
+	}
+
+	for lo := -5; lo < 105; lo++ {
+		for hi := lo - 1; hi < lo+15; hi++ {
+			assert.Equal(t, tree.RangeCount(lo, hi) > 0, tree.AnyInRange(lo, hi), "[%d, %d]", lo, hi)
+		}
+	}
+
+	assert.True(t, tree.AnyInRange(10, 10))
+	assert.False(t, tree.AnyInRange(11, 19))
+	assert.False(t, tree.AnyInRange(20, 10))
+	assert.False(t, New(1, cmpInt).AnyInRange(0, 100))
+}
+
+func TestTreap_ReplaceEqual(t *testing.T) {
+
+	type entry struct {
+		id      int
+		payload string
+	}
+	byId := func(i1, i2 interface{}) bool { return i1.(entry).id < i2.(entry).id }
+
+	tree := New(1, byId)
+	for i := 0; i < 100; i++ {
+		tree.Insert(entry{i, "old"})
+	}
+	cpy := tree.Copy()
+	snapshot := tree.Snapshot()
+
+	assert.True(t, tree.ReplaceEqual(entry{42, "new"}))
+	assert.False(t, tree.ReplaceEqual(entry{100, "new"}))
+	assert.Equal(t, 100, tree.Size())
+
+	assert.Equal(t, "new", tree.Search(entry{42, ""}).(entry).payload)
+	assert.Equal(t, "old", snapshot.Search(entry{42, ""}).(entry).payload)
+	assert.True(t, tree.TopologicalEqual(cpy))
+	assert.True(t, snapshot.TopologicalEqual(cpy))
+}
+
+func TestTreap_DeepCopy(t *testing.T) {
+
+	type sample struct{ value int }
+	less := func(i1, i2 interface{}) bool { return i1.(*sample).value < i2.(*sample).value }
+	clone := func(key interface{}) interface{} { s := *key.(*sample); return &s }
+
+	tree := New(1, less)
+	for i := 0; i < 100; i++ {
+		tree.Insert(&sample{i})
+	}
+
+	cpy := tree.DeepCopy(clone)
+	assert.True(t, cpy.TopologicalEqual(tree))
+	assert.True(t, checkAll(*cpy.rootPtr, less))
+
+	cpy.Search(&sample{50}).(*sample).value = 1000 // breaks the order of cpy, not of tree
+	assert.NotNil(t, tree.Search(&sample{50}))
+	assert.True(t, checkAll(*tree.rootPtr, less))
+
+	assert.Panics(t, func() {
+		tree.DeepCopy(func(key interface{}) interface{} { return &sample{-1} })
+	})
+}
+
+func TestTreap_Duplicates(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 50; i++ {
+		tree.Insert(i)
+	}
+	assert.False(t, tree.HasDuplicates())
+	assert.Equal(t, 0, len(tree.Duplicates()))
+
+	tree.InsertDup(0)
+	tree.InsertDup(25)
+	tree.InsertDup(25)
+	tree.InsertDup(49)
+	assert.True(t, tree.HasDuplicates())
+	assert.Equal(t, []interface{}{0, 25, 49}, tree.Duplicates())
+
+	empty := New(1, cmpInt)
+	assert.False(t, empty.HasDuplicates())
+	assert.Equal(t, 0, len(empty.Duplicates()))
+}
+
+func TestTreap_CheckCounts(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	assert.Nil(t, tree.CheckCounts())
+
+	ts, tg := tree.SplitByKey(49)
+	assert.Nil(t, ts.CheckCounts())
+	assert.Nil(t, tg.CheckCounts())
+	ts.JoinExclusive(tg)
+	assert.Nil(t, ts.CheckCounts())
+	assert.Nil(t, New(1, cmpInt).CheckCounts())
+
+	p := __choose(*ts.rootPtr, 10)
+	p.count++
+	err := ts.CheckCounts()
+	assert.NotNil(t, err)
+	assert.Regexp(t, "count violated", err.Error())
+	p.count--
+	assert.Nil(t, ts.CheckCounts())
+}
+
+func TestTreap_NewWithAllocator(t *testing.T) {
+
+	arena := make([]Node, 0, 1000)
+	free := make([]*Node, 0)
+	allocated, freed := 0, 0
+	alloc := func() *Node {
+		allocated++
+		if n := len(free); n > 0 {
+			p := free[n-1]
+			free = free[:n-1]
+			return p
+		}
+		arena = append(arena, Node{})
+		return &arena[len(arena)-1]
+	}
+	release := func(p *Node) {
+		freed++
+		free = append(free, p)
+	}
+
+	tree := NewWithAllocator(1, cmpInt, alloc, release)
+	for i := 0; i < 500; i++ {
+		tree.Insert(i)
+	}
+	assert.Equal(t, 500, allocated)
+	assert.Equal(t, 500, len(arena))
+
+	for i := 0; i < 500; i += 2 {
+		tree.Remove(i)
+	}
+	assert.Equal(t, 250, freed)
+	assert.True(t, tree.check())
+
+	rhs := New(2, cmpInt)
+	for i := 0; i < 500; i++ {
+		rhs.Insert(i)
+	}
+	tree.Union(rhs) // the 250 copies of keys already contained are given back
+	assert.Equal(t, 500, tree.Size())
+	assert.True(t, tree.check())
+	assert.Equal(t, 1000, allocated)
+	assert.Equal(t, 500, freed)
+	assert.Equal(t, 500, len(arena)) // the released nodes were reused
+
+	tree.Clear()
+	assert.Equal(t, 1000, freed)
+	assert.Equal(t, allocated, freed)
+
+	heap := NewWithAllocator(1, cmpInt, nil, nil, 3, 1, 2)
+	assert.Equal(t, 0, heap.Compare(NewTreap(cmpInt, 1, 2, 3)))
+}
+
+func TestIterator_FailFast(t *testing.T) {
+
+	const msg = "Iterator invalidated by concurrent modification of the tree"
+	tree := New(1, cmpInt)
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+
+	it := NewIterator(tree)
+	it.Next()
+	tree.Insert(1000)
+	assert.PanicsWithValue(t, msg, func() { it.Next() })
+	assert.PanicsWithValue(t, msg, func() { it.Prev() })
+	assert.PanicsWithValue(t, msg, func() { it.GetCurr() })
+	assert.PanicsWithValue(t, msg, func() { it.Seek(5) })
+
+	mutations := []func(){
+		func() { tree.Remove(1000) },
+		func() { tree.InsertDup(5) },
+		func() { tree.RemoveByPos(0) },
+		func() { tree.ExtractMax() },
+		func() { tree.SearchOrInsert(2000) },
+		func() { ts, tg := tree.SplitByKey(50); ts.JoinExclusive(tg); tree.Swap(ts) },
+		func() { tree.Rebalance() },
+		func() { tree.Clear() },
+	}
+	for _, mutate := range mutations {
+		it := NewIterator(tree)
+		mutate()
+		assert.PanicsWithValue(t, msg, func() { it.GetCurr() })
+	}
+
+	// failed insertions and removals, as well as key replacements, do not invalidate
+	for i := 0; i < 10; i++ {
+		tree.Insert(i)
+	}
+	it = NewIterator(tree)
+	tree.Insert(3)
+	tree.Remove(1000)
+	tree.SearchOrInsert(4)
+	tree.Upsert(5, func(existing interface{}) interface{} { return existing })
+	tree.ReplaceEqual(6)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, i, it.GetCurr())
+		it.Next()
+	}
+	assert.False(t, it.HasCurr())
+}
+
+func TestTreap_SearchGEAndSearchLE(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 100; i += 10 {
+		tree.Insert(i)
+	}
+	tree.InsertDup(50)
+	tree.InsertDup(50) // keys: 0 10 20 30 40 50 50 50 60 70 80 90
+
+	for key := -5; key <= 95; key++ {
+		ge, geRank, geOk := tree.SearchGE(key)
+		le, leRank, leOk := tree.SearchLE(key)
+		assert.Equal(t, key <= 90, geOk)
+		assert.Equal(t, key >= 0, leOk)
+		if geOk {
+			assert.Equal(t, tree.Choose(geRank), ge)
+			assert.Equal(t, tree.CountLess(key), geRank)
+			assert.False(t, ge.(int) < key)
+		}
+		if leOk {
+			assert.Equal(t, tree.Choose(leRank), le)
+			assert.Equal(t, tree.Size()-tree.CountGreater(key)-1, leRank)
+			assert.False(t, le.(int) > key)
+		}
+	}
+
+	_, rank, _ := tree.SearchGE(50)
+	assert.Equal(t, 5, rank)
+	_, rank, _ = tree.SearchLE(50)
+	assert.Equal(t, 7, rank)
+
+	_, _, ok := New(1, cmpInt).SearchGE(0)
+	assert.False(t, ok)
+}
+
+func TestTreap_ExtractRanges(t *testing.T) {
+
+	const N = 100
+	tree := New(1, cmpInt)
+	for i := 0; i < N; i++ {
+		tree.Insert(i)
+	}
+
+	ranges := [][2]int{{0, 4}, {10, 10}, {50, 59}, {95, 99}}
+	parts := tree.ExtractRanges(ranges)
+	assert.Equal(t, len(ranges), len(parts))
+	for i, r := range ranges {
+		assert.True(t, parts[i].check())
+		assert.Equal(t, r[1]-r[0]+1, parts[i].Size())
+		for pos := r[0]; pos <= r[1]; pos++ {
+			assert.Equal(t, pos, parts[i].Choose(pos-r[0]))
+		}
+	}
+
+	assert.True(t, tree.check())
+	assert.Equal(t, N-5-1-10-5, tree.Size())
+	for _, key := range []int{0, 4, 10, 50, 59, 95, 99} {
+		assert.False(t, tree.Has(key))
+	}
+	for _, key := range []int{5, 9, 11, 49, 60, 94} {
+		assert.True(t, tree.Has(key))
+	}
+
+	whole := tree.ExtractRanges([][2]int{{0, tree.Size() - 1}})
+	assert.True(t, tree.IsEmpty())
+	assert.Equal(t, N-21, whole[0].Size())
+	assert.Equal(t, 0, len(tree.ExtractRanges(nil)))
+
+	tree = New(1, cmpInt, 0, 1, 2, 3, 4, 5)
+	assert.Panics(t, func() { tree.ExtractRanges([][2]int{{0, 2}, {2, 3}}) }) // overlap
+	assert.Panics(t, func() { tree.ExtractRanges([][2]int{{3, 4}, {0, 1}}) }) // not ascending
+	assert.Panics(t, func() { tree.ExtractRanges([][2]int{{2, 1}}) })
+	assert.Panics(t, func() { tree.ExtractRanges([][2]int{{4, 6}}) })
+	assert.Panics(t, func() { tree.ExtractRanges([][2]int{{-1, 0}}) })
+	assert.Equal(t, 6, tree.Size())
+}
+
+func TestTreap_KendallDistance(t *testing.T) {
+
+	tree := New(1, cmpInt, 0, 1, 2, 3, 4)
+	assert.Equal(t, 0, tree.KendallDistance([]interface{}{0, 1, 2, 3, 4}))
+	assert.Equal(t, 10, tree.KendallDistance([]interface{}{4, 3, 2, 1, 0}))
+	assert.Equal(t, 1, tree.KendallDistance([]interface{}{1, 0, 2, 3, 4}))
+	assert.Equal(t, 4, tree.KendallDistance([]interface{}{4, 0, 1, 2, 3}))
+
+	const N = 300
+	tree = New(1, cmpInt)
+	reference := make([]interface{}, N)
+	for i, v := range rand.New(rand.NewSource(2)).Perm(N) {
+		tree.Insert(i)
+		reference[i] = v
+	}
+	expected := 0
+	for i := 0; i < N; i++ {
+		for j := i + 1; j < N; j++ {
+			if reference[i].(int) > reference[j].(int) {
+				expected++
+			}
+		}
+	}
+	assert.Equal(t, expected, tree.KendallDistance(reference))
+
+	tree = New(1, cmpInt, 0, 1, 2)
+	assert.Panics(t, func() { tree.KendallDistance([]interface{}{0, 1}) })
+	assert.Panics(t, func() { tree.KendallDistance([]interface{}{0, 1, 5}) })
+	assert.Panics(t, func() { tree.KendallDistance([]interface{}{0, 1, 1}) })
+	assert.Equal(t, 0, New(1, cmpInt).KendallDistance(nil))
+}
+
+func TestTreap_KeepTopAndKeepBottom(t *testing.T) {
+
+	build := func() *Treap {
+		tree := New(1, cmpInt)
+		for i := 0; i < 100; i++ {
+			tree.Insert(i)
+		}
+		return tree
+	}
+
+	tree := build()
+	tree.KeepTop(10)
+	assert.True(t, tree.check())
+	assert.Equal(t, []interface{}{90, 91, 92, 93, 94, 95, 96, 97, 98, 99}, tree.ToSlice())
+	tree.KeepTop(20)
+	assert.Equal(t, 10, tree.Size())
+
+	tree = build()
+	tree.KeepBottom(3)
+	assert.True(t, tree.check())
+	assert.Equal(t, []interface{}{0, 1, 2}, tree.ToSlice())
+	tree.KeepBottom(0)
+	assert.True(t, tree.IsEmpty())
+
+	pooled := NewPooled(1, cmpInt)
+	for i := 0; i < 100; i++ {
+		pooled.Insert(i)
+	}
+	pooled.KeepTop(1)
+	assert.Equal(t, []interface{}{99}, pooled.ToSlice())
+	assert.Equal(t, 100, pooled.NodeCount())
+
+	assert.Panics(t, func() { tree.KeepTop(-1) })
+}
+
+func TestIterator_GetCurrWithPos(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 100; i += 2 {
+		tree.Insert(i)
+	}
+
+	for it := NewIterator(tree); it.HasCurr(); it.Next() {
+		key, pos := it.GetCurrWithPos()
+		_, rank := tree.RankInOrder(key)
+		assert.Equal(t, rank, pos)
+		assert.Equal(t, 2*pos, key)
+	}
+
+	key, pos := NewRangeIterator(tree, 31, 60).GetCurrWithPos()
+	assert.Equal(t, 32, key)
+	assert.Equal(t, 16, pos)
+
+	it := NewIterator(tree)
+	it.ResetLast().Next()
+	assert.Panics(t, func() { it.GetCurrWithPos() })
+}
+
+func TestTreap_ExtractRangeByKey(t *testing.T) {
+
+	build := func() *Treap {
+		tree := New(1, cmpInt)
+		for i := 0; i < 100; i += 2 {
+			tree.Insert(i)
+		}
+		tree.InsertDup(20)
+		return tree
+	}
+
+	tree := build()
+	extracted := tree.ExtractRangeByKey(19, 31) // 20 20 22 24 26 28 30
+	assert.True(t, tree.check())
+	assert.True(t, extracted.check())
+	assert.Equal(t, []interface{}{20, 20, 22, 24, 26, 28, 30}, extracted.ToSlice())
+	assert.Equal(t, 51-7, tree.Size())
+	assert.False(t, tree.AnyInRange(19, 31))
+	assert.True(t, tree.Has(18))
+	assert.True(t, tree.Has(32))
+
+	tree = build()
+	assert.Equal(t, 51, tree.ExtractRangeByKey(-10, 1000).Size())
+	assert.True(t, tree.IsEmpty())
+
+	tree = build()
+	assert.True(t, tree.ExtractRangeByKey(30, 20).IsEmpty())
+	assert.True(t, tree.ExtractRangeByKey(200, 300).IsEmpty())
+	assert.True(t, tree.ExtractRangeByKey(21, 21).IsEmpty())
+	assert.Equal(t, 51, tree.Size())
+	assert.Equal(t, []interface{}{0, 2}, tree.ExtractRangeByKey(-5, 2).ToSlice())
+	assert.Equal(t, []interface{}{98}, tree.ExtractRangeByKey(98, 98).ToSlice())
+	assert.Equal(t, 48, tree.Size())
+}
+
+func TestTreap_RankRange(t *testing.T) {
+
+	tree := New(1, cmpInt, 0, 1, 1, 1, 2, 4, 4) // positions 0 1 2 3 4 5 6
+
+	for _, c := range []struct{ key, first, last int }{{0, 0, 0}, {1, 1, 3}, {2, 4, 4}, {4, 5, 6}} {
+		first, last, ok := tree.RankRange(c.key)
+		assert.True(t, ok)
+		assert.Equal(t, c.first, first)
+		assert.Equal(t, c.last, last)
+		for pos := first; pos <= last; pos++ {
+			assert.Equal(t, c.key, tree.Choose(pos))
+		}
+	}
+
+	for _, key := range []int{-1, 3, 5} {
+		_, _, ok := tree.RankRange(key)
+		assert.False(t, ok)
+	}
+	_, _, ok := New(1, cmpInt).RankRange(0)
+	assert.False(t, ok)
+}
+
+func TestFromSortedChan(t *testing.T) {
+
+	const N = 1000
+	ch := make(chan interface{})
+	go func() {
+		for i := 0; i < N; i++ {
+			ch <- i / 2 // every key twice
+		}
+		close(ch)
+	}()
+
+	tree := FromSortedChan(1, cmpInt, ch)
+	assert.True(t, tree.check())
+	assert.Equal(t, N, tree.Size())
+	for i := 0; i < N; i++ {
+		assert.Equal(t, i/2, tree.Choose(i))
+	}
+
+	empty := make(chan interface{})
+	close(empty)
+	assert.True(t, FromSortedChan(1, cmpInt, empty).IsEmpty())
+
+	unsorted := make(chan interface{}, 3)
+	unsorted <- 1
+	unsorted <- 3
+	unsorted <- 2
+	close(unsorted)
+	assert.PanicsWithValue(t, "Keys at positions 1 and 2 are out of order", func() {
+		FromSortedChan(1, cmpInt, unsorted)
+	})
+}
+
+func TestTreap_SetDiffs(t *testing.T) {
+
+	tree := New(1, cmpInt, 0, 1, 2, 2, 3, 5, 8)
+	rhs := New(2, cmpInt, 2, 3, 4, 8, 8, 9)
+	treeCopy, rhsCopy := tree.Copy(), rhs.Copy()
+
+	onlyLeft, both, onlyRight := tree.SetDiffs(rhs)
+	assert.Equal(t, []interface{}{0, 1, 5}, onlyLeft.ToSlice())
+	assert.Equal(t, []interface{}{2, 3, 8}, both.ToSlice())
+	assert.Equal(t, []interface{}{4, 9}, onlyRight.ToSlice())
+	for _, result := range []*Treap{onlyLeft, both, onlyRight} {
+		assert.True(t, result.check())
+	}
+
+	assert.True(t, tree.TopologicalEqual(treeCopy))
+	assert.True(t, rhs.TopologicalEqual(rhsCopy))
+
+	empty := New(3, cmpInt)
+	onlyLeft, both, onlyRight = tree.SetDiffs(empty)
+	assert.Equal(t, []interface{}{0, 1, 2, 3, 5, 8}, onlyLeft.ToSlice())
+	assert.True(t, both.IsEmpty())
+	assert.True(t, onlyRight.IsEmpty())
+
+	onlyLeft, both, onlyRight = empty.SetDiffs(rhs)
+	assert.True(t, onlyLeft.IsEmpty())
+	assert.True(t, both.IsEmpty())
+	assert.Equal(t, []interface{}{2, 3, 4, 8, 9}, onlyRight.ToSlice())
+}
+
+func TestTreap_Subtract(t *testing.T) {
+
+	build := func() *Treap {
+		tree := New(1, cmpInt)
+		for i := 0; i < 1000; i++ {
+			tree.Insert(i)
+		}
+		tree.InsertDup(500)
+		return tree
+	}
+
+	// a small rhs is removed key by key and a large one through a merge
+	for _, step := range []int{250, 3} {
+		tree := build()
+		rhs := New(2, cmpInt)
+		for i := 0; i < 1500; i += step {
+			rhs.InsertDup(i)
+			rhs.InsertDup(i)
+		}
+		rhsCopy := rhs.Copy()
+		expected := tree.Difference(rhs)
+
+		removed := tree.Subtract(rhs)
+		assert.True(t, tree.check())
+		assert.Equal(t, 1001-tree.Size(), removed)
+		assert.True(t, tree.Equal(expected))
+		assert.True(t, rhs.TopologicalEqual(rhsCopy))
+	}
+
+	tree := build()
+	assert.Equal(t, 0, tree.Subtract(New(2, cmpInt)))
+	assert.Equal(t, 0, New(2, cmpInt).Subtract(tree))
+	assert.Equal(t, 1001, tree.Subtract(tree.Copy()))
+	assert.True(t, tree.IsEmpty())
+
+	pooled := NewPooled(3, cmpInt)
+	for i := 0; i < 100; i++ {
+		pooled.Insert(i)
+	}
+	assert.Equal(t, 100, pooled.Subtract(pooled))
+	assert.True(t, pooled.IsEmpty())
+	assert.True(t, pooled.check())
+	pooled.Insert(7)
+	assert.Equal(t, "[7]", pooled.String())
+}
+
+func TestTreap_NthAfter(t *testing.T) {
+
+	tree := New(1, cmpInt)
+	for i := 0; i < 100; i += 10 {
+		tree.Insert(i)
+	}
+	tree.InsertDup(30) // 0 10 20 30 30 40 50 60 70 80 90
+
+	for _, c := range []struct{ key, n, expected int }{
+		{30, 1, 40}, {30, 2, 50}, {25, 1, 30}, {25, 2, 30}, {25, 3, 40}, {-5, 1, 0}, {-5, 11, 90}, {89, 1, 90},
+	} {
+		key, ok := tree.NthAfter(c.key, c.n)
+		assert.True(t, ok, "%d %d", c.key, c.n)
+		assert.Equal(t, c.expected, key, "%d %d", c.key, c.n)
+	}
+
+	for _, c := range []struct{ key, n int }{{90, 1}, {80, 2}, {-5, 12}, {1000, 1}, {2, math.MaxInt}} {
+		key, ok := tree.NthAfter(c.key, c.n)
+		assert.False(t, ok)
+		assert.Nil(t, key)
+	}
+
+	assert.Panics(t, func() { tree.NthAfter(0, 0) })
+}
+
+func TestTreap_AllocatorBalanceOnDerivedTrees(t *testing.T) {
+
+	live := make(map[*Node]bool) // nodes handed out by alloc and not yet freed
+	allocated, freed := 0, 0
+	alloc := func() *Node {
+		allocated++
+		p := &Node{}
+		live[p] = true
+		return p
+	}
+	release := func(p *Node) {
+		assert.True(t, live[p], "free received a node not handed out by alloc")
+		delete(live, p)
+		freed++
+	}
+
+	tree := NewWithAllocator(1, cmpInt, alloc, release)
+	for i := 0; i < 5; i++ {
+		tree.Insert(i)
+	}
+	rhs := New(2, cmpInt, 3, 4, 5, 6)
+
+	tree.Copy().Clear()
+	tree.DeepCopy(func(key interface{}) interface{} { return key }).Clear()
+	tree.Difference(rhs).Clear()
+	tree.SymmetricDifference(rhs).Clear()
+	tree.UnionCopy(rhs).Clear()
+	tree.SliceRange(0, 2).Clear()
+	tree.IntersectionMerge(rhs).Clear()
+	cpy := tree.Copy()
+	cpy.KeepTop(2)
+	cpy.Clear()
+
+	var buf bytes.Buffer
+	_, err := tree.WriteTo(&buf, encodeInt)
+	assert.Nil(t, err)
+	read := NewWithAllocator(3, cmpInt, alloc, release)
+	_, err = (&TreapReader{Tree: read, DecodeKey: decodeInt}).ReadFrom(&buf)
+	assert.Nil(t, err)
+	read.Clear()
+
+	// the nodes copied on write from a snapshot were not given by alloc
+	snapshot := tree.Snapshot()
+	tree.Remove(0)
+	tree.Insert(10)
+	tree.Clear()
+	snapshot.Clear()
+
+	// only the 5 nodes kept by the snapshot, whose release is left to the garbage collector, remain
+	assert.Equal(t, allocated, freed+len(live))
+	assert.Equal(t, 5, len(live))
+}